@@ -0,0 +1,509 @@
+package superimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"slices"
+)
+
+// AnimatedImage is a sequence of frames shown one after the other, like an animated GIF.
+// Its fields follow gif.GIF, but the frames can be any image so effects can produce them.
+type AnimatedImage struct {
+	// Frames are drawn in order on the canvas, each one at its bounds.
+	Frames []image.Image
+
+	// Delay is the display time of every frame, in 100ths of a second.
+	Delay []int
+
+	// Disposal tells how the area of every frame is cleared before drawing the next one:
+	// gif.DisposalNone keeps it, gif.DisposalBackground makes it transparent and
+	// gif.DisposalPrevious restores what was there before the frame. Missing values
+	// are gif.DisposalNone.
+	Disposal []byte
+
+	// Blend tells how every frame is drawn on the canvas. Missing values are BlendOver.
+	// GIF files can only blend over, APNG files can do both.
+	Blend []BlendOp
+
+	// LoopCount is the number of times the animation is restarted, as in gif.GIF:
+	// 0 loops forever and -1 shows every frame once.
+	LoopCount int
+
+	// Config holds the size of the canvas. A zero size covers the bounds of every frame.
+	Config image.Config
+
+	// BackgroundIndex is the background color of GIF files, kept to write them back.
+	BackgroundIndex byte
+
+	// Format used by EncodeAll. Empty means gif.
+	Format string
+}
+
+// BlendOp is the way a frame of an AnimatedImage is drawn on the canvas.
+type BlendOp byte
+
+const (
+	// BlendOver composites the frame over the canvas.
+	BlendOver BlendOp = iota
+	// BlendSource replaces the pixels of the canvas in the area of the frame.
+	BlendSource
+)
+
+// NewAnimated returns the AnimatedImage of a decoded GIF. The frames are shared, not copied.
+func NewAnimated(g *gif.GIF) *AnimatedImage {
+	a := &AnimatedImage{
+		Frames:          make([]image.Image, len(g.Image)),
+		Delay:           slices.Clone(g.Delay),
+		Disposal:        slices.Clone(g.Disposal),
+		LoopCount:       g.LoopCount,
+		Config:          g.Config,
+		BackgroundIndex: g.BackgroundIndex,
+		Format:          "gif",
+	}
+	for i, frame := range g.Image {
+		a.Frames[i] = frame
+	}
+
+	return a
+}
+
+// DecodeAll decodes every frame of an animation from r, detecting its format by
+// its magic bytes. Formats without animations are decoded as a single frame.
+func DecodeAll(r io.Reader) (*AnimatedImage, error) {
+	pr := asPeeker(r)
+	f, err := sniff(pr)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.decodeAll != nil {
+		return f.decodeAll(pr)
+	}
+
+	img, err := Decode(pr, f.name)
+	if err != nil {
+		return nil, err
+	}
+
+	return singleFrame(img), nil
+}
+
+// singleFrame returns an animation with img as its only frame.
+func singleFrame(img *SuperImage) *AnimatedImage {
+	bounds := img.Bounds()
+	return &AnimatedImage{
+		Frames: []image.Image{img.Image},
+		Delay:  []int{0},
+		Config: image.Config{Width: bounds.Max.X, Height: bounds.Max.Y},
+		Format: img.Format(),
+	}
+}
+
+// EncodeAll writes every frame of an animation to w in the format of a,
+// which must support animations. If opts is nil, DefaultEncodeOptions is used.
+func EncodeAll(w io.Writer, a *AnimatedImage, opts *EncodeOptions) error {
+	if opts == nil {
+		opts = DefaultEncodeOptions
+	}
+
+	format := a.Format
+	if format == "" {
+		format = "gif"
+	}
+
+	f, err := lookupFormat(format)
+	if err != nil {
+		return err
+	}
+
+	if f.encodeAll == nil {
+		return fmt.Errorf("%w: %s animation", ErrUnsupportedFormat, format)
+	}
+
+	return f.encodeAll(w, a, opts)
+}
+
+// Bounds returns the canvas of the animation.
+func (a *AnimatedImage) Bounds() image.Rectangle {
+	if a.Config.Width > 0 && a.Config.Height > 0 {
+		return image.Rect(0, 0, a.Config.Width, a.Config.Height)
+	}
+
+	var r image.Rectangle
+	for _, frame := range a.Frames {
+		r = r.Union(frame.Bounds())
+	}
+	return image.Rect(0, 0, r.Max.X, r.Max.Y)
+}
+
+func (a *AnimatedImage) delay(i int) int {
+	if i < len(a.Delay) {
+		return a.Delay[i]
+	}
+	return 0
+}
+
+func (a *AnimatedImage) disposal(i int) byte {
+	if i < len(a.Disposal) {
+		return a.Disposal[i]
+	}
+	return gif.DisposalNone
+}
+
+func (a *AnimatedImage) blend(i int) BlendOp {
+	if i < len(a.Blend) {
+		return a.Blend[i]
+	}
+	return BlendOver
+}
+
+// Coalesce returns a copy of the animation where every frame is the whole canvas
+// as it is displayed at that moment, so frames can be edited on their own.
+func (a *AnimatedImage) Coalesce(opts *Options) (*AnimatedImage, error) {
+	ctx := opts.context()
+	canvas := image.NewRGBA(a.Bounds())
+	var previous []uint8
+
+	frames := make([]image.Image, len(a.Frames))
+	for i, frame := range a.Frames {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		disposal := a.disposal(i)
+		if disposal == gif.DisposalPrevious {
+			previous = append(previous[:0], canvas.Pix...)
+		}
+
+		if a.blend(i) == BlendSource {
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		}
+		drawOver(canvas, frame)
+		frames[i] = &image.RGBA{
+			Pix:    slices.Clone(canvas.Pix),
+			Stride: canvas.Stride,
+			Rect:   canvas.Rect,
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous)
+		}
+	}
+
+	return a.withFrames(frames), nil
+}
+
+// Map applies an effect to every frame of the animation, using a worker per frame.
+// The frames are coalesced first, so effects see each frame as it is displayed.
+// Any effect works, including functions and pipelines wrapped in an EffectFunc:
+//
+//	out, err := anim.Map(superimage.PixelateEffect{Radius: 4}, nil)
+func (a *AnimatedImage) Map(effect Effect, opts *Options) (*AnimatedImage, error) {
+	coalesced, err := a.Coalesce(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every frame runs on a single goroutine, the parallelism is between frames.
+	frameOpts := &Options{Workers: 1, Context: opts.context()}
+
+	frames := coalesced.Frames
+	errs := make([]error, len(frames))
+	err = parallelFor(opts, len(frames), func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			var frame image.Image
+			frame, errs[i] = effect.Apply(nil, frames[i], frameOpts)
+			frames[i] = unwrap(frame)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i, err)
+		}
+	}
+
+	out := a.withFrames(frames)
+	out.Config = image.Config{}
+	out.Config.Width, out.Config.Height = out.Bounds().Dx(), out.Bounds().Dy()
+	return out, nil
+}
+
+// withFrames returns a copy of a with whole canvas frames, each one replacing the previous.
+func (a *AnimatedImage) withFrames(frames []image.Image) *AnimatedImage {
+	disposal := make([]byte, len(frames))
+	delay := make([]int, len(frames))
+	for i := range frames {
+		disposal[i] = gif.DisposalBackground
+		delay[i] = a.delay(i)
+	}
+
+	return &AnimatedImage{
+		Frames:          frames,
+		Delay:           delay,
+		Disposal:        disposal,
+		LoopCount:       a.LoopCount,
+		Config:          a.Config,
+		BackgroundIndex: a.BackgroundIndex,
+		Format:          a.Format,
+	}
+}
+
+// drawOver composites img over canvas, reading its rows with a scanner
+// so every common image type takes a fast path.
+func drawOver(canvas *image.RGBA, img image.Image) {
+	r := img.Bounds().Intersect(canvas.Rect)
+	if r.Empty() {
+		return
+	}
+
+	s := newScanner(img)
+	row := make([]uint8, r.Dx()*4)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		s.scan(r.Min.X, y, r.Max.X, row)
+		i := canvas.PixOffset(r.Min.X, y)
+		dst := canvas.Pix[i : i+len(row)]
+
+		for x := 0; x < len(row); x += 4 {
+			src := row[x : x+4 : x+4]
+			d := dst[x : x+4 : x+4]
+
+			switch a := uint32(src[3]); a {
+			case 0:
+			case 0xff:
+				copy(d, src)
+			default:
+				// Premultiply the source and add the part of the canvas it lets through.
+				rest := 0xff - a
+				d[0] = uint8((uint32(src[0])*a + uint32(d[0])*rest + 0x7f) / 0xff)
+				d[1] = uint8((uint32(src[1])*a + uint32(d[1])*rest + 0x7f) / 0xff)
+				d[2] = uint8((uint32(src[2])*a + uint32(d[2])*rest + 0x7f) / 0xff)
+				d[3] = uint8((0xff*a + uint32(d[3])*rest + 0x7f) / 0xff)
+			}
+		}
+	}
+}
+
+// GIF converts the animation to a gif.GIF. Frames that are not paletted keep
+// their colors if they fit in opts.NumColors, and are quantized with opts otherwise,
+// which can be nil. Without opts.Quantizer, frames are quantized with MedianCut
+// and those with transparent pixels keep a transparent color.
+func (a *AnimatedImage) GIF(opts *gif.Options) *gif.GIF {
+	bounds := a.Bounds()
+	g := &gif.GIF{
+		Image:           make([]*image.Paletted, len(a.Frames)),
+		Delay:           make([]int, len(a.Frames)),
+		Disposal:        make([]byte, len(a.Frames)),
+		LoopCount:       a.LoopCount,
+		Config:          image.Config{Width: bounds.Dx(), Height: bounds.Dy()},
+		BackgroundIndex: a.BackgroundIndex,
+	}
+	global, _ := a.Config.ColorModel.(color.Palette)
+	if global != nil {
+		g.Config.ColorModel = global
+	}
+
+	for i, frame := range a.Frames {
+		g.Image[i] = toPaletted(frame, opts, global)
+		g.Delay[i] = a.delay(i)
+		g.Disposal[i] = a.disposal(i)
+	}
+
+	return g
+}
+
+// toPaletted returns img as an *image.Paletted. Images with as many colors as
+// the palette can hold keep them exactly, using the global palette if it has all of
+// them. The others are quantized with opts.Quantizer, or MedianCut by default.
+func toPaletted(img image.Image, opts *gif.Options, global color.Palette) *image.Paletted {
+	img = unwrap(img)
+	if p, ok := img.(*image.Paletted); ok {
+		return p
+	}
+
+	numColors := 256
+	var drawer draw.Drawer = draw.FloydSteinberg
+	var quantizer draw.Quantizer
+	if opts != nil {
+		if opts.NumColors > 0 && opts.NumColors < 256 {
+			numColors = opts.NumColors
+		}
+		if opts.Drawer != nil {
+			drawer = opts.Drawer
+		}
+		if opts.Quantizer != nil {
+			quantizer = opts.Quantizer
+		}
+	}
+
+	h := histogram(img)
+	if len(h) <= numColors {
+		pal := make(color.Palette, len(h))
+		for i, e := range h {
+			pal[i] = e.c
+		}
+		if len(global) <= numColors && hasColors(global, h) {
+			pal = global
+		}
+
+		// Every color is in the palette, so mapping to the closest one is exact.
+		if p, err := mapPalette(img, pal, nil); err == nil {
+			return p
+		}
+	}
+
+	var pal color.Palette
+	if quantizer != nil {
+		pal = quantizer.Quantize(make(color.Palette, 0, numColors), img)
+	} else {
+		// A transparent entry of its own keeps the transparency.
+		opaque := slices.DeleteFunc(slices.Clone(h), func(e histEntry) bool { return e.c.A == 0 })
+		if len(opaque) < len(h) {
+			pal = append(MedianCut.palette(opaque, numColors-1), color.Transparent)
+		} else {
+			pal = MedianCut.palette(h, numColors)
+		}
+	}
+
+	bounds := img.Bounds()
+	p := image.NewPaletted(bounds, pal)
+	drawer.Draw(p, bounds, img, bounds.Min)
+	return p
+}
+
+// hasColors reports whether the palette holds every color of the histogram h.
+func hasColors(palette color.Palette, h []histEntry) bool {
+	colors := make(map[color.NRGBA]bool, len(palette))
+	for _, c := range paletteColors(palette) {
+		if c.A == 0 {
+			c = color.NRGBA{}
+		}
+		colors[c] = true
+	}
+
+	for _, e := range h {
+		if !colors[e.c] {
+			return false
+		}
+	}
+	return true
+}
+
+// isOpaque reports whether img has no transparent pixels. Images without an
+// Opaque method are scanned.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+
+	bounds := img.Bounds()
+	s := newScanner(img)
+	row := make([]uint8, bounds.Dx()*4)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		s.scan(bounds.Min.X, y, bounds.Max.X, row)
+		for i := 3; i < len(row); i += 4 {
+			if row[i] != 0xff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func decodeAllGIF(r io.Reader) (*AnimatedImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGIFFrames(data); err != nil {
+		return nil, err
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAnimated(g), nil
+}
+
+// checkGIFFrames rejects the frames of a GIF file whose image data is too short for
+// their size, as gif.DecodeAll allocates every frame before reading its data. A byte
+// of LZW data never decodes to more than lzwMaxCodes pixels. Other errors are left
+// to gif.DecodeAll.
+func checkGIFFrames(data []byte) error {
+	const (
+		extension  = 0x21
+		descriptor = 0x2c
+	)
+
+	// The header and logical screen descriptor, then the global color table.
+	if len(data) < 13 {
+		return nil
+	}
+	p := 13
+	if data[10]&0x80 != 0 {
+		p += 3 << (data[10]&7 + 1)
+	}
+
+	for p < len(data) {
+		switch data[p] {
+		case extension:
+			_, p = gifSubBlocks(data, p+2)
+		case descriptor:
+			if p+10 > len(data) {
+				return nil
+			}
+			width := binary.LittleEndian.Uint16(data[p+5:])
+			height := binary.LittleEndian.Uint16(data[p+7:])
+			flags := data[p+9]
+			p += 10
+			if flags&0x80 != 0 {
+				p += 3 << (flags&7 + 1)
+			}
+
+			// Skip the minimum code size of the LZW data.
+			var n int
+			n, p = gifSubBlocks(data, p+1)
+			if uint64(width)*uint64(height) > uint64(n)*lzwMaxCodes {
+				return formatError("gif", "truncated image data")
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// gifSubBlocks returns the bytes of data held by the sub-blocks that start at p,
+// and where they end.
+func gifSubBlocks(data []byte, p int) (n, end int) {
+	for p < len(data) && data[p] != 0 {
+		n += min(int(data[p]), len(data)-p-1)
+		p += 1 + int(data[p])
+	}
+	return n, p + 1
+}
+
+func encodeAllGIF(w io.Writer, a *AnimatedImage, opts *EncodeOptions) error {
+	// GIF frames always blend over the canvas, the others need whole frames.
+	if slices.Contains(a.Blend, BlendSource) {
+		var err error
+		if a, err = a.Coalesce(nil); err != nil {
+			return err
+		}
+	}
+
+	return gif.EncodeAll(w, a.GIF(opts.GifOpts))
+}
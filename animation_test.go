@@ -0,0 +1,163 @@
+package superimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"testing"
+)
+
+var (
+	testRed   = color.NRGBA{0xff, 0, 0, 0xff}
+	testBlue  = color.NRGBA{0, 0, 0xff, 0xff}
+	testGreen = color.NRGBA{0, 0xff, 0, 0xff}
+	testWhite = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// testAnimation returns an 8x6 animation with a frame of every disposal method,
+// and the color of every frame at (x, y) once coalesced.
+func testAnimation() (*AnimatedImage, func(i, x, y int) color.NRGBA) {
+	frame := func(r image.Rectangle, c color.Color) image.Image {
+		img := image.NewNRGBA(r)
+		draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+		return img
+	}
+
+	a := &AnimatedImage{
+		Frames: []image.Image{
+			frame(image.Rect(0, 0, 8, 6), testRed),
+			frame(image.Rect(2, 2, 4, 4), testBlue),
+			frame(image.Rect(0, 0, 2, 2), testGreen),
+			frame(image.Rect(6, 4, 8, 6), testWhite),
+		},
+		Delay:     []int{10, 20, 30, 40},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+		LoopCount: 3,
+		Config:    image.Config{Width: 8, Height: 6},
+	}
+
+	want := func(i, x, y int) color.NRGBA {
+		p := image.Pt(x, y)
+		blue := p.In(image.Rect(2, 2, 4, 4))
+		switch {
+		case i == 1 && blue:
+			return testBlue
+		case i >= 2 && blue:
+			return color.NRGBA{}
+		case i == 2 && p.In(image.Rect(0, 0, 2, 2)):
+			return testGreen
+		case i == 3 && p.In(image.Rect(6, 4, 8, 6)):
+			return testWhite
+		}
+		return testRed
+	}
+
+	return a, want
+}
+
+// assertFrames fails if any frame of a, coalesced, differs from want.
+func assertFrames(t *testing.T, a *AnimatedImage, want func(i, x, y int) color.NRGBA) {
+	t.Helper()
+
+	coalesced, err := a.Coalesce(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coalesced.Frames) != 4 {
+		t.Fatalf("%d frames, want 4", len(coalesced.Frames))
+	}
+	for i, frame := range coalesced.Frames {
+		if frame.Bounds() != image.Rect(0, 0, 8, 6) {
+			t.Fatalf("frame %d: bounds = %v, want the canvas", i, frame.Bounds())
+		}
+		for y := range 6 {
+			for x := range 8 {
+				g := color.NRGBAModel.Convert(frame.At(x, y)).(color.NRGBA)
+				if w := want(i, x, y); g != w && (g.A != 0 || w.A != 0) {
+					t.Fatalf("frame %d: pixel (%d, %d) = %v, want %v", i, x, y, g, w)
+				}
+			}
+		}
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	a, want := testAnimation()
+	assertFrames(t, a, want)
+}
+
+func TestGIFRoundTrip(t *testing.T) {
+	a, want := testAnimation()
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, a, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Format != "gif" || decoded.LoopCount != a.LoopCount {
+		t.Fatalf("format %q and loop count %d, want gif and %d", decoded.Format, decoded.LoopCount, a.LoopCount)
+	}
+	for i, d := range decoded.Delay {
+		if d != a.Delay[i] {
+			t.Fatalf("frame %d: delay = %d, want %d", i, d, a.Delay[i])
+		}
+	}
+	assertFrames(t, decoded, want)
+}
+
+func TestMapAnimation(t *testing.T) {
+	a, want := testAnimation()
+	negated, err := a.Map(NegativeEffect{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertFrames(t, negated, func(i, x, y int) color.NRGBA {
+		c := want(i, x, y)
+		if c.A == 0 {
+			return c
+		}
+		return color.NRGBA{0xff - c.R, 0xff - c.G, 0xff - c.B, c.A}
+	})
+}
+
+func TestGIFRejectsTruncated(t *testing.T) {
+	a, _ := testAnimation()
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, a, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A 65535x65535 frame with a single byte of image data.
+	huge := []byte("GIF89a")
+	huge = binary.LittleEndian.AppendUint16(huge, 0xffff)
+	huge = binary.LittleEndian.AppendUint16(huge, 0xffff)
+	huge = append(huge, 0x80, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff)
+	huge = append(huge, 0x2c, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 2, 1, 0x4c, 0, 0x3b)
+
+	tests := map[string][]byte{
+		"half":       buf.Bytes()[:buf.Len()/2],
+		"header":     buf.Bytes()[:13],
+		"huge frame": huge,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			var err error
+			n := allocated(func() {
+				_, err = DecodeAll(bytes.NewReader(data))
+			})
+			if err == nil {
+				t.Fatal("decode succeeded")
+			}
+			if n > maxTestAlloc {
+				t.Fatalf("decode allocated %d bytes before failing with %v", n, err)
+			}
+		})
+	}
+}
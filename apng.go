@@ -0,0 +1,526 @@
+package superimage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+)
+
+// APNG dispose and blend operations, as stored in fcTL chunks.
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+
+	apngBlendSource = 0
+	apngBlendOver   = 1
+)
+
+// pngChunk is a chunk of a png file.
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// pngChunks splits a png file in chunks, checking their CRC. It stops after IEND.
+func pngChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, formatError("png", "bad signature")
+	}
+	data = data[len(pngSignature):]
+
+	var chunks []pngChunk
+	for len(data) >= 12 {
+		length := binary.BigEndian.Uint32(data)
+		if uint64(length)+12 > uint64(len(data)) {
+			return nil, formatError("png", "truncated chunk")
+		}
+
+		typ := data[4:8]
+		body := data[8 : 8+length]
+		if crc32.Update(crc32.ChecksumIEEE(typ), crc32.IEEETable, body) != binary.BigEndian.Uint32(data[8+length:]) {
+			return nil, formatError("png", "bad CRC in "+string(typ)+" chunk")
+		}
+
+		chunks = append(chunks, pngChunk{typ: string(typ), data: body})
+		data = data[12+length:]
+
+		if string(typ) == "IEND" {
+			return chunks, nil
+		}
+	}
+
+	return nil, formatError("png", "missing IEND chunk")
+}
+
+// apngFrame is a frame of an APNG file while it is decoded.
+type apngFrame struct {
+	control []byte
+	data    []byte
+}
+
+// decodeAllPNG decodes every frame of an APNG file. Files without an acTL chunk
+// are decoded as a single frame.
+func decodeAllPNG(r io.Reader) (*AnimatedImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var header, animation []byte
+	// PLTE and tRNS are shared by every frame.
+	var shared []pngChunk
+	var frames []*apngFrame
+	var current *apngFrame
+
+	for _, c := range chunks {
+		switch c.typ {
+		case "IHDR":
+			header = c.data
+		case "PLTE", "tRNS":
+			shared = append(shared, c)
+		case "acTL":
+			animation = c.data
+		case "fcTL":
+			if len(c.data) != 26 {
+				return nil, formatError("apng", "bad fcTL chunk")
+			}
+			current = &apngFrame{control: c.data}
+			frames = append(frames, current)
+		case "IDAT":
+			// The default image is only a frame if an fcTL comes before it.
+			if current != nil {
+				current.data = append(current.data, c.data...)
+			}
+		case "fdAT":
+			if current == nil || len(c.data) < 4 {
+				return nil, formatError("apng", "bad fdAT chunk")
+			}
+			current.data = append(current.data, c.data[4:]...)
+		}
+	}
+
+	if animation == nil {
+		img, err := Decode(bytes.NewReader(data), "png")
+		if err != nil {
+			return nil, err
+		}
+		return singleFrame(img), nil
+	}
+
+	if len(header) != 13 || len(animation) != 8 {
+		return nil, formatError("apng", "bad IHDR or acTL chunk")
+	}
+	if len(frames) == 0 {
+		return nil, ErrNoFrames
+	}
+
+	canvas := image.Rect(0, 0, int(binary.BigEndian.Uint32(header)), int(binary.BigEndian.Uint32(header[4:])))
+	if canvas.Empty() || canvas.Dx() > maxImagePixels/canvas.Dy() {
+		return nil, formatError("apng", "invalid canvas size")
+	}
+	bitsPerPixel, ok := pngBitsPerPixel(header[8], header[9])
+	if !ok {
+		return nil, formatError("apng", "bad IHDR chunk")
+	}
+	a := &AnimatedImage{
+		Frames:    make([]image.Image, len(frames)),
+		Delay:     make([]int, len(frames)),
+		Disposal:  make([]byte, len(frames)),
+		Blend:     make([]BlendOp, len(frames)),
+		LoopCount: apngLoopCount(binary.BigEndian.Uint32(animation[4:])),
+		Config:    image.Config{Width: canvas.Dx(), Height: canvas.Dy()},
+		Format:    "png",
+	}
+
+	for i, f := range frames {
+		c := f.control
+		width, height := binary.BigEndian.Uint32(c[4:]), binary.BigEndian.Uint32(c[8:])
+		x, y := binary.BigEndian.Uint32(c[12:]), binary.BigEndian.Uint32(c[16:])
+		if uint64(x)+uint64(width) > uint64(canvas.Max.X) || uint64(y)+uint64(height) > uint64(canvas.Max.Y) {
+			return nil, formatError("apng", "frame outside of the canvas")
+		}
+		// image/png allocates the frame before inflating its data.
+		rowBytes := (uint64(width)*bitsPerPixel + 7) / 8
+		if uint64(height)*rowBytes > uint64(len(f.data))*deflateMaxRatio {
+			return nil, formatError("apng", "truncated frame data")
+		}
+
+		// Every frame is decoded as a png file of its own, with the header of the animation.
+		var buf bytes.Buffer
+		buf.Write(pngSignature)
+		frameHeader := bytes.Clone(header)
+		binary.BigEndian.PutUint32(frameHeader, width)
+		binary.BigEndian.PutUint32(frameHeader[4:], height)
+		writePNGChunk(&buf, "IHDR", frameHeader)
+		for _, s := range shared {
+			writePNGChunk(&buf, s.typ, s.data)
+		}
+		writePNGChunk(&buf, "IDAT", f.data)
+		writePNGChunk(&buf, "IEND", nil)
+
+		img, err := png.Decode(&buf)
+		if err != nil {
+			return nil, err
+		}
+
+		a.Frames[i] = translate(img, image.Pt(int(x), int(y)))
+		a.Delay[i] = apngDelay(binary.BigEndian.Uint16(c[20:]), binary.BigEndian.Uint16(c[22:]))
+
+		switch c[24] {
+		case apngDisposeBackground:
+			a.Disposal[i] = gif.DisposalBackground
+		case apngDisposePrevious:
+			a.Disposal[i] = gif.DisposalPrevious
+		default:
+			a.Disposal[i] = gif.DisposalNone
+		}
+
+		if c[25] == apngBlendSource {
+			a.Blend[i] = BlendSource
+		}
+	}
+
+	return a, nil
+}
+
+// deflateMaxRatio is the most bytes that a byte of zlib data can inflate to.
+const deflateMaxRatio = 1032
+
+// pngBitsPerPixel returns the bits of every pixel of a png file with the given bit
+// depth and color type, and whether the pair is valid.
+func pngBitsPerPixel(depth, colorType uint8) (uint64, bool) {
+	var channels uint64
+	switch colorType {
+	case 0, 3:
+		channels = 1
+	case 4:
+		channels = 2
+	case 2:
+		channels = 3
+	case 6:
+		channels = 4
+	default:
+		return 0, false
+	}
+	if depth == 0 || depth > 16 || depth&(depth-1) != 0 {
+		return 0, false
+	}
+	return channels * uint64(depth), true
+}
+
+// apngDelay converts a delay of num/den seconds to 100ths of a second.
+// A zero denominator means 100.
+func apngDelay(num, den uint16) int {
+	if den == 0 {
+		den = 100
+	}
+	return int(math.Round(float64(num) * 100 / float64(den)))
+}
+
+// apngLoopCount converts the number of plays of an acTL chunk to a gif.GIF loop count.
+func apngLoopCount(plays uint32) int {
+	switch {
+	case plays == 0:
+		return 0
+	case plays == 1:
+		return -1
+	default:
+		return int(min(plays-1, math.MaxInt32))
+	}
+}
+
+// translate moves the bounds of img by p without copying its pixels.
+func translate(img image.Image, p image.Point) image.Image {
+	if p == (image.Point{}) {
+		return img
+	}
+
+	switch m := img.(type) {
+	case *image.NRGBA:
+		m.Rect = m.Rect.Add(p)
+	case *image.NRGBA64:
+		m.Rect = m.Rect.Add(p)
+	case *image.RGBA:
+		m.Rect = m.Rect.Add(p)
+	case *image.RGBA64:
+		m.Rect = m.Rect.Add(p)
+	case *image.Gray:
+		m.Rect = m.Rect.Add(p)
+	case *image.Gray16:
+		m.Rect = m.Rect.Add(p)
+	case *image.Paletted:
+		m.Rect = m.Rect.Add(p)
+	default:
+		nrgba, _ := toNRGBA(img, nil)
+		nrgba.Rect = nrgba.Rect.Add(p)
+		return nrgba
+	}
+
+	return img
+}
+
+// encodeAllPNG writes an animation as an APNG file. Every frame is stored as 8-bit
+// RGB, or RGBA if any of them has transparent pixels.
+func encodeAllPNG(w io.Writer, a *AnimatedImage, opts *EncodeOptions) error {
+	if len(a.Frames) == 0 {
+		return ErrNoFrames
+	}
+
+	canvas := a.Bounds()
+	if canvas.Empty() {
+		return ErrInvalidSize
+	}
+
+	// The first frame is also the default image and must cover the canvas.
+	frames := make([]image.Image, len(a.Frames))
+	copy(frames, a.Frames)
+	if frames[0].Bounds() != canvas {
+		first := image.NewRGBA(canvas)
+		drawOver(first, frames[0])
+		frames[0] = first
+	}
+
+	alpha := false
+	for _, frame := range frames {
+		if !isOpaque(unwrap(frame)) {
+			alpha = true
+			break
+		}
+	}
+
+	level := zlib.DefaultCompression
+	if opts.PngEnc != nil {
+		level = pngZlibLevel(opts.PngEnc.CompressionLevel)
+	}
+
+	header := make([]byte, 13)
+	binary.BigEndian.PutUint32(header, uint32(canvas.Dx()))
+	binary.BigEndian.PutUint32(header[4:], uint32(canvas.Dy()))
+	header[8] = 8
+	header[9] = 2
+	if alpha {
+		header[9] = 6
+	}
+
+	animation := make([]byte, 8)
+	binary.BigEndian.PutUint32(animation, uint32(len(frames)))
+	binary.BigEndian.PutUint32(animation[4:], apngPlays(a.LoopCount))
+
+	if _, err := w.Write(pngSignature); err != nil {
+		return err
+	}
+	if err := writePNGChunk(w, "IHDR", header); err != nil {
+		return err
+	}
+	if err := writePNGChunk(w, "acTL", animation); err != nil {
+		return err
+	}
+
+	var seq uint32
+	for i, frame := range frames {
+		r := frame.Bounds().Intersect(canvas)
+		if r.Empty() {
+			return ErrInvalidSize
+		}
+
+		control := make([]byte, 26)
+		binary.BigEndian.PutUint32(control, seq)
+		binary.BigEndian.PutUint32(control[4:], uint32(r.Dx()))
+		binary.BigEndian.PutUint32(control[8:], uint32(r.Dy()))
+		binary.BigEndian.PutUint32(control[12:], uint32(r.Min.X))
+		binary.BigEndian.PutUint32(control[16:], uint32(r.Min.Y))
+		binary.BigEndian.PutUint16(control[20:], uint16(min(a.delay(i), math.MaxUint16)))
+		binary.BigEndian.PutUint16(control[22:], 100)
+		switch a.disposal(i) {
+		case gif.DisposalBackground:
+			control[24] = apngDisposeBackground
+		case gif.DisposalPrevious:
+			control[24] = apngDisposePrevious
+		default:
+			control[24] = apngDisposeNone
+		}
+		control[25] = apngBlendOver
+		if a.blend(i) == BlendSource {
+			control[25] = apngBlendSource
+		}
+		if err := writePNGChunk(w, "fcTL", control); err != nil {
+			return err
+		}
+		seq++
+
+		data, err := pngImageData(frame, r, alpha, level)
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			err = writePNGChunk(w, "IDAT", data)
+		} else {
+			err = writePNGChunk(w, "fdAT", append(binary.BigEndian.AppendUint32(nil, seq), data...))
+			seq++
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return writePNGChunk(w, "IEND", nil)
+}
+
+// apngPlays converts a gif.GIF loop count to the number of plays of an acTL chunk.
+func apngPlays(loopCount int) uint32 {
+	switch {
+	case loopCount == 0:
+		return 0
+	case loopCount < 0:
+		return 1
+	default:
+		return uint32(min(loopCount+1, math.MaxInt32))
+	}
+}
+
+// pngZlibLevel maps a png.CompressionLevel to a zlib level.
+func pngZlibLevel(level png.CompressionLevel) int {
+	switch level {
+	case png.NoCompression:
+		return zlib.NoCompression
+	case png.BestSpeed:
+		return zlib.BestSpeed
+	case png.BestCompression:
+		return zlib.BestCompression
+	default:
+		return zlib.DefaultCompression
+	}
+}
+
+// pngImageData returns the compressed and filtered rows of the part r of img,
+// as 8-bit RGB or RGBA pixels.
+func pngImageData(img image.Image, r image.Rectangle, alpha bool, level int) ([]byte, error) {
+	bpp := 3
+	if alpha {
+		bpp = 4
+	}
+
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newScanner(unwrap(img))
+	row := make([]uint8, r.Dx()*4)
+	cur := make([]uint8, r.Dx()*bpp)
+	prev := make([]uint8, r.Dx()*bpp)
+	var filtered [5][]uint8
+	for i := range filtered {
+		filtered[i] = make([]uint8, 1+len(cur))
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		s.scan(r.Min.X, y, r.Max.X, row)
+		if alpha {
+			copy(cur, row)
+		} else {
+			for x := range r.Dx() {
+				copy(cur[x*3:x*3+3], row[x*4:x*4+3])
+			}
+		}
+
+		// Uncompressed data gains nothing from filters, like in image/png.
+		line := filtered[0]
+		if level == zlib.NoCompression {
+			copy(line[1:], cur)
+		} else {
+			line = pngFilter(cur, prev, bpp, &filtered)
+		}
+		if _, err := zw.Write(line); err != nil {
+			return nil, err
+		}
+
+		cur, prev = prev, cur
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pngFilter applies every png filter to cur and returns the line, with its filter
+// type first, that has the smallest sum of absolute differences, like image/png does.
+func pngFilter(cur, prev []uint8, bpp int, out *[5][]uint8) []uint8 {
+	best, bestSum := 0, math.MaxInt
+	for ft := range out {
+		if sum := pngFilterLine(ft, cur, prev, bpp, out[ft]); sum < bestSum {
+			best, bestSum = ft, sum
+		}
+	}
+
+	return out[best]
+}
+
+// pngFilterLine writes to out the filter type ft followed by cur filtered with it,
+// and returns the sum of the absolute differences.
+func pngFilterLine(ft int, cur, prev []uint8, bpp int, out []uint8) int {
+	out[0] = uint8(ft)
+
+	sum := 0
+	for i, v := range cur {
+		var left, up, upLeft uint8
+		if i >= bpp {
+			left, upLeft = cur[i-bpp], prev[i-bpp]
+		}
+		up = prev[i]
+
+		var predicted uint8
+		switch ft {
+		case 1:
+			predicted = left
+		case 2:
+			predicted = up
+		case 3:
+			predicted = uint8((int(left) + int(up)) / 2)
+		case 4:
+			predicted = paeth(left, up, upLeft)
+		}
+
+		d := v - predicted
+		out[i+1] = d
+		sum += abs(int(int8(d)))
+	}
+
+	return sum
+}
+
+// paeth is the predictor of the png Paeth filter.
+func paeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,127 @@
+package superimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"slices"
+	"testing"
+)
+
+func TestAPNGRoundTrip(t *testing.T) {
+	a, want := testAnimation()
+	a.Format = "png"
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, a, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Format != "png" || decoded.LoopCount != a.LoopCount || !slices.Equal(decoded.Delay, a.Delay) {
+		t.Fatalf("format %q, loop count %d and delays %v, want png, %d and %v",
+			decoded.Format, decoded.LoopCount, decoded.Delay, a.LoopCount, a.Delay)
+	}
+	assertFrames(t, decoded, want)
+}
+
+func TestBlendSource(t *testing.T) {
+	a, want := testAnimation()
+	// A translucent frame that replaces the canvas instead of blending over it.
+	translucent := image.NewNRGBA(image.Rect(4, 0, 6, 2))
+	draw.Draw(translucent, translucent.Rect, image.NewUniform(color.NRGBA{0, 0, 0xff, 0x80}), image.Point{}, draw.Src)
+	// It replaces the white frame.
+	a.Frames[3] = translucent
+	a.Blend = []BlendOp{BlendOver, BlendOver, BlendOver, BlendSource}
+	wantSource := func(i, x, y int) color.NRGBA {
+		if i == 3 && image.Pt(x, y).In(translucent.Rect) {
+			return color.NRGBA{0, 0, 0xff, 0x80}
+		}
+		if i == 3 && image.Pt(x, y).In(image.Rect(6, 4, 8, 6)) {
+			return testRed
+		}
+		return want(i, x, y)
+	}
+	assertFrames(t, a, wantSource)
+
+	for _, format := range []string{"png", "gif"} {
+		t.Run(format, func(t *testing.T) {
+			a.Format = format
+			var buf bytes.Buffer
+			if err := EncodeAll(&buf, a, nil); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodeAll(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format == "gif" {
+				// GIF has no translucency, but the blue must not be blended over the red.
+				if c := color.NRGBAModel.Convert(decoded.Frames[3].At(4, 0)).(color.NRGBA); c.R != 0 || c.B == 0 {
+					t.Fatalf("pixel (4, 0) = %v, want a blue with no red", c)
+				}
+				return
+			}
+			assertFrames(t, decoded, wantSource)
+		})
+	}
+}
+
+// apngFile returns an APNG file of a gray canvas with one frame of the given size,
+// holding data.
+func apngFile(canvasW, canvasH, width, height uint32, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+
+	header := binary.BigEndian.AppendUint32(nil, canvasW)
+	header = binary.BigEndian.AppendUint32(header, canvasH)
+	writePNGChunk(&buf, "IHDR", append(header, 8, 0, 0, 0, 0))
+	writePNGChunk(&buf, "acTL", []byte{0, 0, 0, 1, 0, 0, 0, 0})
+
+	control := binary.BigEndian.AppendUint32(nil, 0)
+	control = binary.BigEndian.AppendUint32(control, width)
+	control = binary.BigEndian.AppendUint32(control, height)
+	control = append(control, make([]byte, 14)...)
+	writePNGChunk(&buf, "fcTL", control)
+	writePNGChunk(&buf, "IDAT", data)
+	writePNGChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+func TestAPNGRejectsTruncated(t *testing.T) {
+	a, _ := testAnimation()
+	a.Format = "png"
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, a, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A zlib stream of a single empty stored block.
+	empty := []byte{0x78, 0x01, 0x01, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00, 0x00, 0x01}
+	tests := map[string][]byte{
+		"half":          buf.Bytes()[:buf.Len()/2],
+		"huge frame":    apngFile(16384, 16384, 16384, 16384, empty),
+		"huge canvas":   apngFile(1<<20, 1<<20, 1, 1, empty),
+		"outside":       apngFile(10, 10, 11, 10, empty),
+		"empty canvas":  apngFile(0, 10, 0, 10, empty),
+		"no frame data": apngFile(10, 10, 10, 10, nil),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			var err error
+			n := allocated(func() {
+				_, err = DecodeAll(bytes.NewReader(data))
+			})
+			if err == nil {
+				t.Fatal("decode succeeded")
+			}
+			if n > maxTestAlloc {
+				t.Fatalf("decode allocated %d bytes before failing with %v", n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package superimage
+
+import (
+	"image"
+	"math"
+)
+
+// Blur blurs an image by a given radio.
+// If the radio is negative, it returns an error.
+// Radio 0 returns a copy of the original image without any change.
+//
+// Blur is a GaussianBlur whose sigma keeps the strength of previous versions of this function.
+func Blur(img image.Image, radius int) (*SuperImage, error) {
+	if radius < 0 {
+		return nil, ErrNegativeRadio
+	}
+
+	blurred, err := gaussianBlur(nil, img, blurSigma(radius), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLike(blurred, img), nil
+}
+
+// blurSigma converts a Blur radio to the sigma of a Gaussian blur of similar strength.
+func blurSigma(radius int) float64 {
+	r := float64(radius)
+	return math.Sqrt(r*r/3 + r/4)
+}
+
+// BoxBlur replaces every pixel by the average of the square of side 2*radius+1 around it.
+// If the radius is negative, it returns an error. Its cost does not depend on the radius.
+func BoxBlur(img image.Image, radius int) (*SuperImage, error) {
+	if radius < 0 {
+		return nil, ErrNegativeRadio
+	}
+
+	blurred, err := boxBlur(nil, img, radius, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLike(blurred, img), nil
+}
+
+// GaussianBlur blurs an image with a Gaussian kernel of standard deviation sigma.
+// If sigma is negative, NaN or infinite, it returns an error. Its cost does not depend on sigma.
+//
+// The kernel is approximated with three successive box blurs.
+//
+// Reference: https://blog.ivank.net/fastest-gaussian-blur.html
+func GaussianBlur(img image.Image, sigma float64) (*SuperImage, error) {
+	if !validSigma(sigma) {
+		return nil, ErrNegativeSigma
+	}
+
+	blurred, err := gaussianBlur(nil, img, sigma, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLike(blurred, img), nil
+}
+
+// validSigma reports whether sigma is a finite non-negative deviation. NaN is not.
+func validSigma(sigma float64) bool {
+	return sigma >= 0 && !math.IsInf(sigma, 0)
+}
+
+func boxBlur(dst *image.NRGBA, img image.Image, radius int, opts *Options) (*image.NRGBA, error) {
+	return blurBoxes(dst, img, []int{radius}, opts)
+}
+
+func gaussianBlur(dst *image.NRGBA, img image.Image, sigma float64, opts *Options) (*image.NRGBA, error) {
+	return blurBoxes(dst, img, gaussianBoxes(sigma, 3), opts)
+}
+
+// gaussianBoxes returns the radii of n box blurs whose succession approximates
+// a Gaussian blur of standard deviation sigma.
+func gaussianBoxes(sigma float64, n int) []int {
+	idealWidth := math.Sqrt(12*sigma*sigma/float64(n) + 1)
+	lower := int(math.Floor(idealWidth))
+	if lower%2 == 0 {
+		lower--
+	}
+	upper := lower + 2
+
+	fn, fl := float64(n), float64(lower)
+	idealLower := (12*sigma*sigma - fn*fl*fl - 4*fn*fl - 3*fn) / (-4*fl - 4)
+	m := int(math.Round(idealLower))
+
+	radii := make([]int, n)
+	for i := range radii {
+		if i < m {
+			radii[i] = (lower - 1) / 2
+		} else {
+			radii[i] = (upper - 1) / 2
+		}
+	}
+
+	return radii
+}
+
+// blurBoxes applies a box blur for every radius in radii, each of them as
+// a horizontal and a vertical sliding-window pass.
+func blurBoxes(dst *image.NRGBA, img image.Image, radii []int, opts *Options) (*image.NRGBA, error) {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	if bounds.Empty() {
+		return reuseNRGBA(dst, bounds), nil
+	}
+
+	steps := 2*len(radii) + 3
+	src, err := toNRGBA(img, opts.stage(0, steps))
+	if err != nil {
+		return nil, err
+	}
+
+	// Channels are premultiplied and scaled by 255, so averaging them
+	// keeps the precision and transparent pixels don't darken the result.
+	cur := make([]uint32, width*height*4)
+	err = parallelFor(opts.stage(1, steps), height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			i := src.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			row := src.Pix[i : i+width*4]
+			out := cur[y*width*4 : (y+1)*width*4]
+			for j := 0; j < len(row); j += 4 {
+				a := uint32(row[j+3])
+				out[j+0] = uint32(row[j+0]) * a
+				out[j+1] = uint32(row[j+1]) * a
+				out[j+2] = uint32(row[j+2]) * a
+				out[j+3] = a * 0xff
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Every pass writes its output transposed, so two passes blur both
+	// directions and leave the image back in its orientation.
+	next := make([]uint32, len(cur))
+	w, h := width, height
+	for i, radius := range radii {
+		for pass := range 2 {
+			err = boxPass(next, cur, w, h, radius, opts.stage(2+2*i+pass, steps))
+			if err != nil {
+				return nil, err
+			}
+			cur, next = next, cur
+			w, h = h, w
+		}
+	}
+
+	blurred := reuseNRGBA(dst, bounds)
+	err = parallelFor(opts.stage(steps-1, steps), height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			i := blurred.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			row := blurred.Pix[i : i+width*4]
+			in := cur[y*width*4 : (y+1)*width*4]
+			for j := 0; j < len(row); j += 4 {
+				a := in[j+3]
+				if a == 0 {
+					row[j+0], row[j+1], row[j+2], row[j+3] = 0, 0, 0, 0
+					continue
+				}
+				row[j+0] = uint8(min(in[j+0]*0xff/a, 0xff))
+				row[j+1] = uint8(min(in[j+1]*0xff/a, 0xff))
+				row[j+2] = uint8(min(in[j+2]*0xff/a, 0xff))
+				row[j+3] = uint8((a + 0x7f) / 0xff)
+			}
+		}
+	})
+
+	return blurred, err
+}
+
+// boxPass blurs every row of src, a w*h image with 4 channels per pixel,
+// with a sliding window of side 2*radius+1 and writes the result transposed
+// into dst. Pixels outside the row repeat the edge pixel.
+func boxPass(dst, src []uint32, w, h, radius int, opts *Options) error {
+	n := uint64(2*radius + 1)
+
+	return parallelFor(opts, h, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			row := src[y*w*4 : (y+1)*w*4]
+			first := row[:4]
+			last := row[(w-1)*4:]
+
+			// Window of the first pixel: the left part is all the first pixel
+			// and the right part can go beyond the last one.
+			var sum [4]uint64
+			inside := min(radius, w-1)
+			outside := uint64(radius - inside)
+			for c := range 4 {
+				sum[c] = uint64(radius+1)*uint64(first[c]) + outside*uint64(last[c])
+			}
+			for k := 1; k <= inside; k++ {
+				for c := range 4 {
+					sum[c] += uint64(row[k*4+c])
+				}
+			}
+
+			for x := range w {
+				o := (x*h + y) * 4
+				add := min(x+radius+1, w-1) * 4
+				sub := max(x-radius, 0) * 4
+				for c := range 4 {
+					dst[o+c] = uint32((sum[c] + n/2) / n)
+					sum[c] += uint64(row[add+c])
+					sum[c] -= uint64(row[sub+c])
+				}
+			}
+		}
+	})
+}
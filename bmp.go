@@ -0,0 +1,518 @@
+package superimage
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+)
+
+// Sizes of the BMP file header and of the DIB headers this package writes.
+const (
+	bmpFileHeaderSize = 14
+	bmpCoreHeaderSize = 12
+	bmpInfoHeaderSize = 40
+	bmpV4HeaderSize   = 108
+)
+
+// BMP compression methods.
+const (
+	bmpRGB            = 0
+	bmpRLE8           = 1
+	bmpRLE4           = 2
+	bmpBitFields      = 3
+	bmpAlphaBitFields = 6
+)
+
+// maxImagePixels limits the size of the images decoded from files that declare it
+// before any pixel, like BMP, ICO and TIFF.
+const maxImagePixels = 1 << 28
+
+func decodeBMP(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < bmpFileHeaderSize+bmpCoreHeaderSize || data[0] != 'B' || data[1] != 'M' {
+		return nil, formatError("bmp", "bad header")
+	}
+
+	offset := int(binary.LittleEndian.Uint32(data[10:]))
+	if offset < bmpFileHeaderSize || offset > len(data) {
+		return nil, formatError("bmp", "bad pixel data offset")
+	}
+
+	return decodeDIB(data[bmpFileHeaderSize:], offset-bmpFileHeaderSize, false)
+}
+
+// decodeDIB decodes a device independent bitmap: a DIB header, the optional color
+// masks and palette and the pixels at pixelOffset. A negative pixelOffset means the
+// pixels follow the palette. Icons store a bitmap of twice their height, the pixels
+// followed by a 1-bit transparency mask.
+func decodeDIB(dib []byte, pixelOffset int, icon bool) (image.Image, error) {
+	if len(dib) < 4 {
+		return nil, formatError("bmp", "bad DIB header")
+	}
+
+	headerSize := int(binary.LittleEndian.Uint32(dib))
+	if headerSize != bmpCoreHeaderSize && headerSize < bmpInfoHeaderSize || headerSize > len(dib) {
+		return nil, formatError("bmp", "bad DIB header")
+	}
+
+	var width, height, bpp, compression, colors int
+	if headerSize == bmpCoreHeaderSize {
+		width = int(binary.LittleEndian.Uint16(dib[4:]))
+		height = int(int16(binary.LittleEndian.Uint16(dib[6:])))
+		bpp = int(binary.LittleEndian.Uint16(dib[10:]))
+	} else {
+		width = int(int32(binary.LittleEndian.Uint32(dib[4:])))
+		height = int(int32(binary.LittleEndian.Uint32(dib[8:])))
+		bpp = int(binary.LittleEndian.Uint16(dib[14:]))
+		compression = int(binary.LittleEndian.Uint32(dib[16:]))
+		colors = int(binary.LittleEndian.Uint32(dib[32:]))
+	}
+
+	topDown := height < 0
+	if topDown {
+		height = -height
+	}
+	if icon {
+		height /= 2
+	}
+	if width <= 0 || height <= 0 || width > maxImagePixels/height {
+		return nil, formatError("bmp", "bad image size")
+	}
+
+	pos := headerSize
+	var masks [4]uint32
+	switch {
+	case compression != bmpBitFields && compression != bmpAlphaBitFields:
+		switch bpp {
+		case 16:
+			masks = [4]uint32{0x7c00, 0x3e0, 0x1f, 0}
+		case 24, 32:
+			masks = [4]uint32{0xff0000, 0xff00, 0xff, 0xff000000}
+		}
+	case headerSize >= 52:
+		// The masks are part of the header from its version 2.
+		for i := range 3 {
+			masks[i] = binary.LittleEndian.Uint32(dib[40+i*4:])
+		}
+		if headerSize >= 56 {
+			masks[3] = binary.LittleEndian.Uint32(dib[52:])
+		}
+	default:
+		n := 3
+		if compression == bmpAlphaBitFields {
+			n = 4
+		}
+		if pos+n*4 > len(dib) {
+			return nil, formatError("bmp", "missing color masks")
+		}
+		for i := range n {
+			masks[i] = binary.LittleEndian.Uint32(dib[pos+i*4:])
+		}
+		pos += n * 4
+	}
+
+	var palette color.Palette
+	if bpp <= 8 {
+		if colors <= 0 || colors > 1<<bpp {
+			colors = 1 << bpp
+		}
+		entry := 4
+		if headerSize == bmpCoreHeaderSize {
+			entry = 3
+		}
+		if pos+colors*entry > len(dib) {
+			return nil, formatError("bmp", "truncated palette")
+		}
+
+		// Indexes beyond the palette are black instead of out of range.
+		palette = make(color.Palette, 1<<bpp)
+		for i := range palette {
+			palette[i] = color.RGBA{A: 0xff}
+			if i < colors {
+				p := dib[pos+i*entry:]
+				palette[i] = color.RGBA{p[2], p[1], p[0], 0xff}
+			}
+		}
+		pos += colors * entry
+	}
+
+	if pixelOffset < 0 {
+		pixelOffset = pos
+	}
+	if pixelOffset > len(dib) {
+		return nil, formatError("bmp", "bad pixel data offset")
+	}
+	pixels := dib[pixelOffset:]
+
+	// row returns the index of the pixel row of the file that holds the image row y.
+	row := func(y int) int {
+		if topDown {
+			return y
+		}
+		return height - 1 - y
+	}
+
+	var img image.Image
+	var err error
+	switch {
+	case compression == bmpRLE8 && bpp == 8, compression == bmpRLE4 && bpp == 4:
+		img, err = decodeBMPRLE(pixels, width, height, bpp, palette)
+	case compression != bmpRGB && compression != bmpBitFields && compression != bmpAlphaBitFields:
+		return nil, formatError("bmp", "unsupported compression")
+	case bpp == 1 || bpp == 2 || bpp == 4 || bpp == 8:
+		img, err = decodeBMPPaletted(pixels, width, height, bpp, palette, row)
+	case bpp == 16 || bpp == 24 || bpp == 32:
+		img, err = decodeBMPTrueColor(pixels, width, height, bpp, masks, row)
+	default:
+		return nil, formatError("bmp", "unsupported bits per pixel")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if icon {
+		stride := bmpStride(width, bpp)
+		return applyIconMask(img, pixels[min(len(pixels), stride*height):], row), nil
+	}
+
+	return img, nil
+}
+
+// bmpStride returns the size of a row of pixels, which is padded to 4 bytes.
+func bmpStride(width, bpp int) int {
+	return (width*bpp + 31) / 32 * 4
+}
+
+func decodeBMPPaletted(pixels []byte, width, height, bpp int, palette color.Palette, row func(int) int) (image.Image, error) {
+	stride := bmpStride(width, bpp)
+	if stride*height > len(pixels) {
+		return nil, formatError("bmp", "truncated pixel data")
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+	perByte := 8 / bpp
+	mask := byte(1<<bpp - 1)
+	for y := range height {
+		src := pixels[row(y)*stride:]
+		dst := img.Pix[y*img.Stride : y*img.Stride+width]
+		for x := range dst {
+			shift := 8 - bpp*(x%perByte+1)
+			dst[x] = src[x/perByte] >> shift & mask
+		}
+	}
+
+	return img, nil
+}
+
+func decodeBMPTrueColor(pixels []byte, width, height, bpp int, masks [4]uint32, row func(int) int) (image.Image, error) {
+	stride := bmpStride(width, bpp)
+	if stride*height > len(pixels) {
+		return nil, formatError("bmp", "truncated pixel data")
+	}
+
+	// Every channel is extracted by its mask and scaled to 8 bits.
+	var shifts [4]int
+	var maxes [4]uint32
+	for i, m := range masks {
+		if m != 0 {
+			shifts[i] = bits.TrailingZeros32(m)
+			maxes[i] = m >> shifts[i]
+		}
+	}
+	channel := func(v uint32, i int) uint8 {
+		if maxes[i] == 0 {
+			return 0xff
+		}
+		return uint8((v & masks[i] >> shifts[i]) * 0xff / maxes[i])
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	bytesPerPixel := bpp / 8
+	transparent := true
+	for y := range height {
+		src := pixels[row(y)*stride:]
+		dst := img.Pix[y*img.Stride : y*img.Stride+width*4]
+		for x := range width {
+			var v uint32
+			switch bytesPerPixel {
+			case 2:
+				v = uint32(binary.LittleEndian.Uint16(src[x*2:]))
+			case 3:
+				p := src[x*3:]
+				v = uint32(p[0]) | uint32(p[1])<<8 | uint32(p[2])<<16
+			case 4:
+				v = binary.LittleEndian.Uint32(src[x*4:])
+			}
+
+			d := dst[x*4 : x*4+4 : x*4+4]
+			d[0], d[1], d[2], d[3] = channel(v, 0), channel(v, 1), channel(v, 2), channel(v, 3)
+			if d[3] != 0 {
+				transparent = false
+			}
+		}
+	}
+
+	// Many writers leave the unused alpha byte of 32-bit pixels at zero.
+	if transparent && maxes[3] != 0 {
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 0xff
+		}
+	}
+
+	return img, nil
+}
+
+// maxRLERatio is the most pixels per byte of run-length encoded data that a BMP
+// may declare. A run of two bytes gives up to 255 pixels, the rest is left for the
+// pixels skipped by deltas and early ends of lines, so a short file cannot force a
+// huge allocation.
+const maxRLERatio = 1 << 10
+
+// decodeBMPRLE decodes the run-length encoding of 8-bit and 4-bit BMP files.
+// Skipped pixels keep the index 0.
+func decodeBMPRLE(pixels []byte, width, height, bpp int, palette color.Palette) (image.Image, error) {
+	if width*height/maxRLERatio > len(pixels) {
+		return nil, formatError("bmp", "truncated pixel data")
+	}
+	img := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+
+	x, y := 0, height-1
+	set := func(v byte) {
+		if x < width && y >= 0 {
+			img.Pix[y*img.Stride+x] = v
+		}
+		x++
+	}
+
+	i := 0
+	for i+1 < len(pixels) && y >= 0 {
+		n, v := int(pixels[i]), pixels[i+1]
+		i += 2
+
+		if n > 0 {
+			// A run of n pixels, alternating two indexes in 4-bit files.
+			for k := range n {
+				if bpp == 4 {
+					set(v >> (4 * (1 - k%2)) & 0xf)
+				} else {
+					set(v)
+				}
+			}
+			continue
+		}
+
+		switch v {
+		case 0:
+			// End of line.
+			x, y = 0, y-1
+		case 1:
+			// End of bitmap.
+			return img, nil
+		case 2:
+			// Delta.
+			if i+1 >= len(pixels) {
+				return img, nil
+			}
+			x += int(pixels[i])
+			y -= int(pixels[i+1])
+			i += 2
+		default:
+			// Absolute mode: v literal indexes, padded to 2 bytes.
+			size := int(v)
+			if bpp == 4 {
+				size = (size + 1) / 2
+			}
+			if i+size > len(pixels) {
+				return nil, formatError("bmp", "truncated pixel data")
+			}
+			for k := range int(v) {
+				if bpp == 4 {
+					set(pixels[i+k/2] >> (4 * (1 - k%2)) & 0xf)
+				} else {
+					set(pixels[i+k])
+				}
+			}
+			i += size + size%2
+		}
+	}
+
+	return img, nil
+}
+
+// applyIconMask makes transparent the pixels set in the 1-bit mask that follows
+// the pixels of an icon. Images with an alpha channel ignore the mask.
+func applyIconMask(img image.Image, mask []byte, row func(int) int) image.Image {
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		for i := 3; i < len(nrgba.Pix); i += 4 {
+			if nrgba.Pix[i] != 0xff {
+				return img
+			}
+		}
+	}
+
+	bounds := img.Bounds()
+	stride := bmpStride(bounds.Dx(), 1)
+	if stride*bounds.Dy() > len(mask) {
+		return img
+	}
+
+	// The image was just decoded, it can be modified in place.
+	out, _ := toNRGBA(img, nil)
+
+	for y := range bounds.Dy() {
+		m := mask[row(y)*stride:]
+		for x := range bounds.Dx() {
+			if m[x/8]&(0x80>>(x%8)) != 0 {
+				out.Pix[y*out.Stride+x*4+3] = 0
+			}
+		}
+	}
+
+	return out
+}
+
+// encodeBMP writes paletted and gray images with 8 bits per pixel, opaque images
+// with 24 bits and the others with 32 bits and an alpha mask.
+func encodeBMP(w io.Writer, m image.Image, _ *EncodeOptions) error {
+	bounds := m.Bounds()
+	if bounds.Empty() {
+		return ErrInvalidSize
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+
+	var palette color.Palette
+	switch img := m.(type) {
+	case *image.Paletted:
+		if len(img.Palette) <= 256 && isOpaquePalette(img.Palette) {
+			palette = img.Palette
+		}
+	case *image.Gray:
+		palette = make(color.Palette, 256)
+		for i := range palette {
+			palette[i] = color.Gray{Y: uint8(i)}
+		}
+	}
+
+	bpp, headerSize := 32, bmpV4HeaderSize
+	switch {
+	case palette != nil:
+		bpp, headerSize = 8, bmpInfoHeaderSize
+	case isOpaque(m):
+		bpp, headerSize = 24, bmpInfoHeaderSize
+	}
+
+	stride := bmpStride(width, bpp)
+	offset := bmpFileHeaderSize + headerSize + len(palette)*4
+	size := offset + stride*height
+
+	header := make([]byte, offset)
+	header[0], header[1] = 'B', 'M'
+	binary.LittleEndian.PutUint32(header[2:], uint32(size))
+	binary.LittleEndian.PutUint32(header[10:], uint32(offset))
+
+	dib := header[bmpFileHeaderSize:]
+	binary.LittleEndian.PutUint32(dib, uint32(headerSize))
+	binary.LittleEndian.PutUint32(dib[4:], uint32(width))
+	binary.LittleEndian.PutUint32(dib[8:], uint32(height))
+	binary.LittleEndian.PutUint16(dib[12:], 1)
+	binary.LittleEndian.PutUint16(dib[14:], uint16(bpp))
+	binary.LittleEndian.PutUint32(dib[20:], uint32(stride*height))
+	// 72 DPI.
+	binary.LittleEndian.PutUint32(dib[24:], 2835)
+	binary.LittleEndian.PutUint32(dib[28:], 2835)
+	binary.LittleEndian.PutUint32(dib[32:], uint32(len(palette)))
+
+	if bpp == 32 {
+		binary.LittleEndian.PutUint32(dib[16:], bmpBitFields)
+		binary.LittleEndian.PutUint32(dib[40:], 0xff0000)
+		binary.LittleEndian.PutUint32(dib[44:], 0xff00)
+		binary.LittleEndian.PutUint32(dib[48:], 0xff)
+		binary.LittleEndian.PutUint32(dib[52:], 0xff000000)
+		// sRGB color space.
+		copy(dib[56:], "BGRs")
+	}
+
+	for i, c := range palette {
+		r, g, b, _ := c.RGBA()
+		copy(dib[headerSize+i*4:], []byte{uint8(b >> 8), uint8(g >> 8), uint8(r >> 8), 0})
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	return writeBMPRows(w, m, bpp, false)
+}
+
+// writeBMPRows writes the pixels of m bottom-up with the given bits per pixel: 8 for
+// the indexes of paletted and gray images, 24 or 32 for the others. Icons also get
+// an empty transparency mask.
+func writeBMPRows(w io.Writer, m image.Image, bpp int, icon bool) error {
+	bounds := m.Bounds()
+	width := bounds.Dx()
+
+	s := newScanner(m)
+	pixels := make([]uint8, width*4)
+	out := make([]uint8, bmpStride(width, bpp))
+
+	// The indexes of paletted and gray images are written as they are.
+	var indexes func(y int) []uint8
+	switch img := m.(type) {
+	case *image.Paletted:
+		indexes = func(y int) []uint8 {
+			i := img.PixOffset(bounds.Min.X, y)
+			return img.Pix[i : i+width]
+		}
+	case *image.Gray:
+		indexes = func(y int) []uint8 {
+			i := img.PixOffset(bounds.Min.X, y)
+			return img.Pix[i : i+width]
+		}
+	}
+
+	for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
+		if bpp == 8 {
+			copy(out, indexes(y))
+		} else {
+			s.scan(bounds.Min.X, y, bounds.Max.X, pixels)
+			n := bpp / 8
+			for x := range width {
+				p := pixels[x*4 : x*4+4 : x*4+4]
+				o := out[x*n : x*n+n : x*n+n]
+				o[0], o[1], o[2] = p[2], p[1], p[0]
+				if n == 4 {
+					o[3] = p[3]
+				}
+			}
+		}
+
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+
+	if icon {
+		mask := make([]byte, bmpStride(width, 1)*bounds.Dy())
+		if _, err := w.Write(mask); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isOpaquePalette reports whether every color of p is opaque.
+func isOpaquePalette(p color.Palette) bool {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package superimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func TestBMPRoundTrip(t *testing.T) {
+	r := image.Rect(3, 2, 42, 21)
+	for _, ti := range scannerImages(r, image.Point{}) {
+		t.Run(ti.name, func(t *testing.T) {
+			got := roundTrip(t, ti.img, &EncodeOptions{Format: "bmp"})
+			assertSameNRGBA(t, got, ti.img)
+		})
+	}
+
+	opaque := image.NewRGBA(r)
+	for i := range opaque.Pix {
+		opaque.Pix[i] = uint8(i)
+		if i%4 == 3 {
+			opaque.Pix[i] = 0xff
+		}
+	}
+	t.Run("opaque", func(t *testing.T) {
+		got := roundTrip(t, opaque, &EncodeOptions{Format: "bmp"})
+		assertSameNRGBA(t, got, opaque)
+	})
+}
+
+// rleBMP returns a bottom-up 8-bit run-length encoded BMP with a gray palette.
+func rleBMP(width, height int32, data []byte) []byte {
+	const offset = bmpFileHeaderSize + bmpInfoHeaderSize + 256*4
+
+	b := []byte("BM")
+	b = binary.LittleEndian.AppendUint32(b, uint32(offset+len(data)))
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, offset)
+
+	b = binary.LittleEndian.AppendUint32(b, bmpInfoHeaderSize)
+	b = binary.LittleEndian.AppendUint32(b, uint32(width))
+	b = binary.LittleEndian.AppendUint32(b, uint32(height))
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, 8)
+	b = binary.LittleEndian.AppendUint32(b, bmpRLE8)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, make([]byte, 8)...)
+	b = binary.LittleEndian.AppendUint32(b, 256)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+
+	for i := range 256 {
+		b = append(b, uint8(i), uint8(i), uint8(i), 0)
+	}
+	return append(b, data...)
+}
+
+func TestBMPDecodesRLE(t *testing.T) {
+	// The bottom row is a run of four 7, the top row the literal indexes 1, 2, 3, 4.
+	data := rleBMP(4, 2, []byte{4, 7, 0, 0, 0, 4, 1, 2, 3, 4, 0, 1})
+	img, err := decodeBMP(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paletted, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("decoded a %T, want *image.Paletted", img)
+	}
+	if want := []uint8{1, 2, 3, 4, 7, 7, 7, 7}; !slices.Equal(paletted.Pix, want) {
+		t.Fatalf("indexes = %v, want %v", paletted.Pix, want)
+	}
+	if c := paletted.At(0, 1); c != (color.RGBA{7, 7, 7, 0xff}) {
+		t.Fatalf("color = %v, want gray 7", c)
+	}
+}
+
+func TestBMPRejectsTruncated(t *testing.T) {
+	rgb := new(bytes.Buffer)
+	if err := Encode(rgb, image.NewRGBA(image.Rect(0, 0, 16, 16)), &EncodeOptions{Format: "bmp"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]byte{
+		"rle header only": rleBMP(16000, 16000, nil),
+		"rle one run":     rleBMP(16000, 16000, []byte{0xff, 1, 0, 1}),
+		"short pixels":    rgb.Bytes()[:rgb.Len()-10],
+		"short header":    rgb.Bytes()[:20],
+		"too big":         rleBMP(1<<20, 1<<20, []byte{0, 1}),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertRejected(t, "bmp", data)
+		})
+	}
+}
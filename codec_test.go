@@ -0,0 +1,74 @@
+package superimage
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"runtime"
+	"testing"
+)
+
+// maxTestAlloc is the most bytes a decoder may allocate for a file that declares
+// a huge image and holds no pixels.
+const maxTestAlloc = 16 << 20
+
+// roundTrip encodes img with opts and decodes it back with the same format.
+func roundTrip(t *testing.T, img image.Image, opts *EncodeOptions) *SuperImage {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, opts); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	decoded, err := Decode(&buf, opts.Format)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return decoded
+}
+
+// assertSameNRGBA fails if got and want differ in size or in any 8-bit
+// non-premultiplied pixel. Fully transparent pixels match whatever their color.
+func assertSameNRGBA(t *testing.T, got, want image.Image) {
+	t.Helper()
+
+	gb, wb := got.Bounds(), want.Bounds()
+	if gb.Size() != wb.Size() {
+		t.Fatalf("size = %v, want %v", gb.Size(), wb.Size())
+	}
+	for y := range wb.Dy() {
+		for x := range wb.Dx() {
+			g := color.NRGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA)
+			w := color.NRGBAModel.Convert(want.At(wb.Min.X+x, wb.Min.Y+y)).(color.NRGBA)
+			if g != w && (g.A != 0 || w.A != 0) {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+// allocated returns the bytes allocated while fn runs.
+func allocated(fn func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+// assertRejected fails if decoding data as format succeeds, or if it allocates
+// more than maxTestAlloc bytes.
+func assertRejected(t *testing.T, format string, data []byte) {
+	t.Helper()
+
+	var err error
+	n := allocated(func() {
+		_, err = Decode(bytes.NewReader(data), format)
+	})
+	if err == nil {
+		t.Fatal("decode succeeded")
+	}
+	if n > maxTestAlloc {
+		t.Fatalf("decode allocated %d bytes before failing with %v", n, err)
+	}
+}
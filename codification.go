@@ -1,120 +1,118 @@
 package superimage
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"image"
-	"image/gif"
-	"image/jpeg"
-	"image/png"
 	"io"
-	"net/http"
-	"net/url"
 	"os"
-	"strings"
 )
 
 // GetByFile gets an image from a current project file.
+// The format is detected from the file content, not from its extension.
 func GetByFile(filename string) (*SuperImage, error) {
-	// Getting the file format.
-	_, format, err := parseURL(filename)
-	if err != nil {
-		return nil, err
-	}
-
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	img, err := Decode(file, format)
-	if err != nil {
-		return nil, err
-	}
-
-	return New(img, format), nil
+	return DecodeAuto(file)
 }
 
 // GetByURL gets an image from an URL with an http GET request.
+// It is equivalent to GetByURLContext with a background context.
 func GetByURL(link string) (*SuperImage, error) {
-	_, format, err := parseURL(link)
-	if err != nil {
-		return nil, err
-	}
+	return GetByURLContext(context.Background(), link)
+}
 
-	req, err := http.NewRequest("GET", link, nil)
-	if err != nil {
-		return nil, err
-	}
+// DefaultDecodeOptions are the options used by Decode, DecodeAuto and GetByFile.
+var DefaultDecodeOptions = &DecodeOptions{}
 
-	req.Header.Set("Content-Type", "image/"+format)
+// DecodeOptions controls how DecodeWithOptions reads an image.
+type DecodeOptions struct {
+	// Format to decode. Empty detects it from the content.
+	Format string
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-
-	b, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
+	// IgnoreOrientation keeps JPEG images as stored instead of applying
+	// the EXIF Orientation tag to their pixels.
+	IgnoreOrientation bool
+}
 
-	img, err := Decode(bytes.NewReader(b), format)
-	if err != nil {
-		return nil, err
-	}
+// Decode decodes an image from r using the specified format.
+// The format can be any registered name or alias (png, jpg, jpeg, gif...).
+func Decode(r io.Reader, format string) (*SuperImage, error) {
+	opts := *DefaultDecodeOptions
+	opts.Format = format
+	return DecodeWithOptions(r, &opts)
+}
 
-	return New(img, format), nil
+// DecodeAuto decodes an image from r detecting its format by its magic bytes.
+// The detected format is stored in the returned SuperImage.
+func DecodeAuto(r io.Reader) (*SuperImage, error) {
+	opts := *DefaultDecodeOptions
+	opts.Format = ""
+	return DecodeWithOptions(r, &opts)
 }
 
-// Decode decodes an image from r using the specified format (png, jpg, jpeg, gif).
-func Decode(r io.Reader, format string) (*SuperImage, error) {
-	var img image.Image
-	var err error
+// DecodeWithOptions decodes an image from r. If opts is nil, DefaultDecodeOptions is used.
+func DecodeWithOptions(r io.Reader, opts *DecodeOptions) (*SuperImage, error) {
+	if opts == nil {
+		opts = DefaultDecodeOptions
+	}
 
-	switch format {
-	case "png":
-		img, err = png.Decode(r)
-		if err != nil {
-			return nil, err
-		}
+	var f *imageFormat
+	var err error
+	format := opts.Format
 
-	case "jpg", "jpeg":
-		img, err = jpeg.Decode(r)
+	if format == "" {
+		pr := asPeeker(r)
+		f, err = sniff(pr)
 		if err != nil {
 			return nil, err
 		}
-
-	case "gif":
-		img, err = gif.Decode(r)
+		r, format = pr, f.name
+	} else {
+		f, err = lookupFormat(format)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-	default:
-		return nil, fmt.Errorf("unsupported format: %s", format)
+	if f.decode == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
 	}
 
-	return New(img, format), nil
-}
+	// Keep the start of JPEG files, where their metadata segments are,
+	// and collect the metadata chunks of PNG files while they are decoded.
+	var head *jpegHead
+	var chunks *pngMetadataReader
+	switch f.name {
+	case "jpeg":
+		head = &jpegHead{}
+		r = io.TeeReader(r, head)
+	case "png":
+		chunks = &pngMetadataReader{}
+		r = io.TeeReader(r, chunks)
+	}
 
-// parseURL calls to the Parse method of the url package.
-func parseURL(link string) (u *url.URL, format string, err error) {
-	u, err = url.Parse(link)
+	img, err := f.decode(r)
 	if err != nil {
-		return u, "", err
+		return nil, err
 	}
 
-	format = u.Path[len(u.Path)-4:]
-	if strings.Contains(format, ".") {
-		format = strings.Split(format, ".")[1]
+	si := New(img, format)
+	if head != nil {
+		si.metadata = jpegMetadata(head.buf)
+		si.orientation = exifOrientation(jpegEXIF(head.buf))
+		if !opts.IgnoreOrientation && si.orientation > OrientationNormal {
+			si.Image = applyOrientation(si.Image, si.orientation)
+			// The pixels are upright now, the stored EXIF must not rotate them again.
+			si.metadata.EXIF = resetOrientation(si.metadata.EXIF)
+		}
 	}
-
-	if format != "png" && format != "jpg" && format != "jpeg" {
-		return u, "", fmt.Errorf("unsupported format: %s", format)
+	if chunks != nil {
+		si.metadata = chunks.metadata()
 	}
 
-	return u, format, nil
+	return si, nil
 }
@@ -0,0 +1,193 @@
+package superimage
+
+import (
+	"image"
+	"math"
+)
+
+// ChannelMatrix mixes the channels of a pixel. Every row computes the red, green and
+// blue output from the input red, green and blue, plus a constant in the last column
+// where 1 is the full channel. Alpha is kept.
+//
+//	r' = m[0][0]*r + m[0][1]*g + m[0][2]*b + m[0][3]*255
+type ChannelMatrix [3][4]float64
+
+// The matrices are unexported so callers cannot change the built-in effects;
+// the functions below return copies.
+var (
+	identityMatrix = ChannelMatrix{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+	}
+
+	negativeMatrix = ChannelMatrix{
+		{-1, 0, 0, 1},
+		{0, -1, 0, 1},
+		{0, 0, -1, 1},
+	}
+
+	sepiaMatrix = ChannelMatrix{
+		{0.393, 0.769, 0.189, 0},
+		{0.349, 0.686, 0.168, 0},
+		{0.272, 0.534, 0.131, 0},
+	}
+)
+
+// IdentityMatrix returns a matrix that leaves the colors unchanged.
+func IdentityMatrix() ChannelMatrix {
+	return identityMatrix
+}
+
+// NegativeMatrix returns a matrix that inverts the colors, like Negative.
+func NegativeMatrix() ChannelMatrix {
+	return negativeMatrix
+}
+
+// SepiaMatrix returns a matrix that gives the brown tones of old photographs,
+// like Sepia at full intensity.
+func SepiaMatrix() ChannelMatrix {
+	return sepiaMatrix
+}
+
+// GrayscaleMethod is the way Grayscale computes the gray of a pixel.
+type GrayscaleMethod int
+
+const (
+	// GrayRec601 weights the channels like SDTV (ITU-R BT.601), as color.GrayModel does.
+	GrayRec601 GrayscaleMethod = iota
+
+	// GrayRec709 weights the channels like HDTV and sRGB (ITU-R BT.709).
+	GrayRec709
+
+	// GrayAverage is the mean of the channels.
+	GrayAverage
+
+	// GrayLightness is the mean of the brightest and the darkest channels.
+	GrayLightness
+)
+
+// grayWeights are the red, green and blue weights of the linear grayscale methods.
+var grayWeights = map[GrayscaleMethod][3]float64{
+	GrayRec601:  {0.299, 0.587, 0.114},
+	GrayRec709:  {0.2126, 0.7152, 0.0722},
+	GrayAverage: {1.0 / 3, 1.0 / 3, 1.0 / 3},
+}
+
+// ChannelMixer mixes the red, green and blue channels of an image with m.
+// NegativeMatrix gives the colors of Negative, and SepiaMatrix those of Sepia.
+func ChannelMixer(img image.Image, m ChannelMatrix) *SuperImage {
+	mixed, _ := mixChannels(nil, img, m, nil)
+	return newLike(mixed, img)
+}
+
+// Grayscale turns an image into shades of gray computed with method.
+// If the method is unknown, it returns an error.
+func Grayscale(img image.Image, method GrayscaleMethod) (*SuperImage, error) {
+	gray, err := grayscale(nil, img, method, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLike(gray, img), nil
+}
+
+// Sepia tones an image like an old photograph. The intensity goes from 0, which
+// leaves it unchanged, to 1. If it is not between 0 and 1, it returns an error.
+func Sepia(img image.Image, intensity float64) (*SuperImage, error) {
+	if intensity > 1 || intensity < 0 {
+		return nil, ErrInvalidIntensity
+	}
+
+	toned, err := mixChannels(nil, img, sepiaIntensity(intensity), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLike(toned, img), nil
+}
+
+// sepiaIntensity blends the identity and sepia matrices.
+func sepiaIntensity(intensity float64) ChannelMatrix {
+	var m ChannelMatrix
+	for i := range m {
+		for j := range m[i] {
+			m[i][j] = identityMatrix[i][j]*(1-intensity) + sepiaMatrix[i][j]*intensity
+		}
+	}
+	return m
+}
+
+func grayscale(dst *image.NRGBA, img image.Image, method GrayscaleMethod, opts *Options) (*image.NRGBA, error) {
+	if method == GrayLightness {
+		return lightness(dst, img, opts)
+	}
+
+	w, ok := grayWeights[method]
+	if !ok {
+		return nil, ErrInvalidMethod
+	}
+
+	row := [4]float64{w[0], w[1], w[2], 0}
+	return mixChannels(dst, img, ChannelMatrix{row, row, row}, opts)
+}
+
+func lightness(dst *image.NRGBA, img image.Image, opts *Options) (*image.NRGBA, error) {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	gray := reuseNRGBA(dst, bounds)
+	s := newScanner(img)
+
+	err := parallelFor(opts, height, func(startY, endY int) {
+		for y := bounds.Min.Y + startY; y < bounds.Min.Y+endY; y++ {
+			i := gray.PixOffset(bounds.Min.X, y)
+			row := gray.Pix[i : i+width*4 : i+width*4]
+			s.scan(bounds.Min.X, y, bounds.Max.X, row)
+
+			for j := 0; j < len(row); j += 4 {
+				p := row[j : j+3 : j+3]
+				v := uint8((int(max(p[0], p[1], p[2])) + int(min(p[0], p[1], p[2])) + 1) / 2)
+				p[0], p[1], p[2] = v, v, v
+			}
+		}
+	})
+
+	return gray, err
+}
+
+func mixChannels(dst *image.NRGBA, img image.Image, m ChannelMatrix, opts *Options) (*image.NRGBA, error) {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	mixed := reuseNRGBA(dst, bounds)
+	s := newScanner(img)
+
+	// Coefficients in 16.16 fixed point, with the rounding in the constant.
+	var k [3][4]int64
+	for i := range m {
+		for j := range 3 {
+			k[i][j] = int64(math.Round(m[i][j] * 0x10000))
+		}
+		k[i][3] = int64(math.Round(m[i][3]*0xff*0x10000)) + 0x8000
+	}
+
+	err := parallelFor(opts, height, func(startY, endY int) {
+		for y := bounds.Min.Y + startY; y < bounds.Min.Y+endY; y++ {
+			i := mixed.PixOffset(bounds.Min.X, y)
+			row := mixed.Pix[i : i+width*4 : i+width*4]
+			s.scan(bounds.Min.X, y, bounds.Max.X, row)
+
+			for j := 0; j < len(row); j += 4 {
+				p := row[j : j+3 : j+3]
+				r, g, b := int64(p[0]), int64(p[1]), int64(p[2])
+				for c := range p {
+					v := (k[c][0]*r + k[c][1]*g + k[c][2]*b + k[c][3]) >> 16
+					p[c] = uint8(min(max(v, 0), 0xff))
+				}
+			}
+		}
+	})
+
+	return mixed, err
+}
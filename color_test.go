@@ -0,0 +1,54 @@
+package superimage
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestChannelMatrices(t *testing.T) {
+	img := scannerImages(image.Rect(0, 0, 40, 30), image.Point{})[0].img
+
+	assertSameNRGBA(t, ChannelMixer(img, IdentityMatrix()), img)
+	assertSameNRGBA(t, ChannelMixer(img, NegativeMatrix()), Negative(img))
+
+	sepia, err := Sepia(img, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameNRGBA(t, ChannelMixer(img, SepiaMatrix()), sepia)
+
+	// The matrices are copies, changing them does not change the effects.
+	m := NegativeMatrix()
+	m[0][0] = 1
+	if NegativeMatrix()[0][0] != -1 {
+		t.Fatal("changing a returned matrix changed NegativeMatrix")
+	}
+}
+
+func TestGrayscale(t *testing.T) {
+	img := scannerImages(image.Rect(0, 0, 40, 30), image.Point{})[0].img
+	for _, method := range []GrayscaleMethod{GrayRec601, GrayRec709, GrayAverage, GrayLightness} {
+		gray, err := Grayscale(img, method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := gray.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(gray.At(x, y)).(color.NRGBA)
+				a := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).A
+				if c.R != c.G || c.G != c.B || c.A != a {
+					t.Fatalf("method %d: pixel (%d, %d) = %v, want gray with alpha %d", method, x, y, c, a)
+				}
+			}
+		}
+	}
+
+	if _, err := Grayscale(img, GrayLightness+1); err != ErrInvalidMethod {
+		t.Fatalf("unknown method: err = %v, want %v", err, ErrInvalidMethod)
+	}
+	if _, err := Sepia(img, 1.5); err != ErrInvalidIntensity {
+		t.Fatalf("intensity 1.5: err = %v, want %v", err, ErrInvalidIntensity)
+	}
+}
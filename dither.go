@@ -0,0 +1,258 @@
+package superimage
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// DitherAlgorithm is a way of spreading the error of mapping an image to a palette,
+// so areas keep their average color.
+type DitherAlgorithm int
+
+const (
+	// FloydSteinberg diffuses the error to 4 neighbors.
+	FloydSteinberg DitherAlgorithm = iota
+
+	// Atkinson diffuses 3/4 of the error to 6 neighbors, which keeps more contrast.
+	Atkinson
+
+	// JarvisJudiceNinke diffuses the error to 12 neighbors over two rows.
+	JarvisJudiceNinke
+
+	// Stucki is a sharper variant of JarvisJudiceNinke.
+	Stucki
+
+	// Sierra diffuses the error to 10 neighbors over two rows.
+	Sierra
+
+	// Bayer2x2, Bayer4x4 and Bayer8x8 compare every pixel with a threshold matrix
+	// of that size instead of diffusing the error, which gives regular patterns.
+	Bayer2x2
+	Bayer4x4
+	Bayer8x8
+)
+
+// ditherTap spreads weight/div of the error of a pixel to the one at (dx, dy) from it.
+type ditherTap struct {
+	dx, dy int
+	weight float32
+}
+
+type ditherKernel struct {
+	div  float32
+	taps []ditherTap
+}
+
+var ditherKernels = map[DitherAlgorithm]ditherKernel{
+	FloydSteinberg: {16, []ditherTap{
+		{1, 0, 7},
+		{-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+	}},
+	Atkinson: {8, []ditherTap{
+		{1, 0, 1}, {2, 0, 1},
+		{-1, 1, 1}, {0, 1, 1}, {1, 1, 1},
+		{0, 2, 1},
+	}},
+	JarvisJudiceNinke: {48, []ditherTap{
+		{1, 0, 7}, {2, 0, 5},
+		{-2, 1, 3}, {-1, 1, 5}, {0, 1, 7}, {1, 1, 5}, {2, 1, 3},
+		{-2, 2, 1}, {-1, 2, 3}, {0, 2, 5}, {1, 2, 3}, {2, 2, 1},
+	}},
+	Stucki: {42, []ditherTap{
+		{1, 0, 8}, {2, 0, 4},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 8}, {1, 1, 4}, {2, 1, 2},
+		{-2, 2, 1}, {-1, 2, 2}, {0, 2, 4}, {1, 2, 2}, {2, 2, 1},
+	}},
+	Sierra: {32, []ditherTap{
+		{1, 0, 5}, {2, 0, 3},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 5}, {1, 1, 4}, {2, 1, 2},
+		{-1, 2, 2}, {0, 2, 3}, {1, 2, 2},
+	}},
+}
+
+// Dither maps every pixel of an image to a color of palette, which must have between
+// 1 and 256 colors, hiding the banding with the given algorithm. For example, a
+// palette of black and white gives 1-bit images. It returns a *SuperImage backed by
+// an *image.Paletted with the bounds of img.
+//
+// The error diffusion algorithms scan the rows in serpentine order, alternating
+// their direction, which avoids the diagonal artifacts of scanning left to right.
+func Dither(img image.Image, palette color.Palette, algorithm DitherAlgorithm) (*SuperImage, error) {
+	if len(palette) < 1 || len(palette) > 256 {
+		return nil, ErrInvalidColors
+	}
+
+	var dithered *image.Paletted
+	var err error
+	switch algorithm {
+	case Bayer2x2, Bayer4x4, Bayer8x8:
+		dithered, err = orderedDither(img, palette, 2<<(algorithm-Bayer2x2), nil)
+	default:
+		kernel, ok := ditherKernels[algorithm]
+		if !ok {
+			return nil, ErrInvalidMethod
+		}
+		dithered = diffuseError(img, palette, kernel)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newLike(dithered, img), nil
+}
+
+// diffuseError dithers img spreading the error of every pixel with kernel.
+// Every row depends on the previous ones, so it runs in a single goroutine.
+func diffuseError(img image.Image, palette color.Palette, kernel ditherKernel) *image.Paletted {
+	img = unwrap(img)
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	dst := image.NewPaletted(bounds, palette)
+	colors := paletteColors(palette)
+	s := newScanner(img)
+	row := make([]uint8, width*4)
+
+	// Error carried to the current row and the ones below it, as many as the kernel reaches.
+	depth := 0
+	for _, t := range kernel.taps {
+		depth = max(depth, t.dy)
+	}
+	errs := make([][]float32, depth+1)
+	for i := range errs {
+		errs[i] = make([]float32, width*4)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		s.scan(bounds.Min.X, y, bounds.Max.X, row)
+		out := dst.Pix[dst.PixOffset(bounds.Min.X, y):]
+		reverse := (y-bounds.Min.Y)%2 == 1
+
+		for step := range width {
+			x, dir := step, 1
+			if reverse {
+				x, dir = width-1-step, -1
+			}
+
+			var c [4]float32
+			var nc [4]uint8
+			for k := range c {
+				c[k] = min(max(float32(row[x*4+k])+errs[0][x*4+k], 0), 0xff)
+				nc[k] = uint8(c[k] + 0.5)
+			}
+
+			i := nearestNRGBA(colors, color.NRGBA{nc[0], nc[1], nc[2], nc[3]})
+			out[x] = uint8(i)
+
+			p := colors[i]
+			e := [4]float32{c[0] - float32(p.R), c[1] - float32(p.G), c[2] - float32(p.B), c[3] - float32(p.A)}
+			for _, t := range kernel.taps {
+				tx := x + t.dx*dir
+				if tx < 0 || tx >= width {
+					continue
+				}
+				w := t.weight / kernel.div
+				next := errs[t.dy][tx*4 : tx*4+4]
+				for k := range next {
+					next[k] += e[k] * w
+				}
+			}
+		}
+
+		// The row below becomes the current one.
+		done := errs[0]
+		clear(done)
+		errs = append(errs[1:], done)
+	}
+
+	return dst
+}
+
+// orderedDither dithers img adding to every pixel the threshold of a Bayer matrix
+// of side n, scaled to the distance between the colors of palette.
+func orderedDither(img image.Image, palette color.Palette, n int, opts *Options) (*image.Paletted, error) {
+	img = unwrap(img)
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	dst := image.NewPaletted(bounds, palette)
+	colors := paletteColors(palette)
+	s := newScanner(img)
+
+	matrix := bayerMatrix(n)
+	spread := paletteSpread(colors)
+	// Offsets centered on zero, so an image of the palette colors is unchanged on average.
+	offsets := make([]float64, len(matrix))
+	for i, v := range matrix {
+		offsets[i] = ((float64(v)+0.5)/float64(len(matrix)) - 0.5) * spread
+	}
+
+	err := parallelFor(opts, bounds.Dy(), func(startY, endY int) {
+		row := make([]uint8, width*4)
+		for y := bounds.Min.Y + startY; y < bounds.Min.Y+endY; y++ {
+			s.scan(bounds.Min.X, y, bounds.Max.X, row)
+			out := dst.Pix[dst.PixOffset(bounds.Min.X, y):]
+			my := (y - bounds.Min.Y) % n
+
+			for x := range width {
+				offset := offsets[my*n+x%n]
+				p := row[x*4 : x*4+4]
+				c := color.NRGBA{
+					R: clampUint8(float64(p[0]) + offset),
+					G: clampUint8(float64(p[1]) + offset),
+					B: clampUint8(float64(p[2]) + offset),
+					A: p[3],
+				}
+				out[x] = uint8(nearestNRGBA(colors, c))
+			}
+		}
+	})
+
+	return dst, err
+}
+
+// bayerMatrix returns the n x n Bayer threshold matrix, n a power of two, by rows.
+// Its values go from 0 to n*n-1.
+func bayerMatrix(n int) []int {
+	m := []int{0}
+	for size := 1; size < n; size *= 2 {
+		next := make([]int, 4*size*size)
+		for y := range size {
+			for x := range size {
+				v := 4 * m[y*size+x]
+				next[y*2*size+x] = v
+				next[y*2*size+x+size] = v + 2
+				next[(y+size)*2*size+x] = v + 3
+				next[(y+size)*2*size+x+size] = v + 1
+			}
+		}
+		m = next
+	}
+	return m
+}
+
+// paletteSpread returns the mean distance from every color of the palette to the
+// closest other color, the amount of noise that ordered dithering must add. The
+// distance is the biggest difference of a channel, as the noise is added to all.
+func paletteSpread(colors []color.NRGBA) float64 {
+	if len(colors) < 2 {
+		return 0xff
+	}
+
+	total := 0.0
+	for i, a := range colors {
+		closest := math.Inf(1)
+		for j, b := range colors {
+			if i == j {
+				continue
+			}
+			d := max(math.Abs(float64(a.R)-float64(b.R)), math.Abs(float64(a.G)-float64(b.G)), math.Abs(float64(a.B)-float64(b.B)))
+			if d > 0 {
+				closest = min(closest, d)
+			}
+		}
+		if !math.IsInf(closest, 1) {
+			total += closest
+		}
+	}
+	return total / float64(len(colors))
+}
@@ -0,0 +1,75 @@
+package superimage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDitherPalette(t *testing.T) {
+	img := scannerImages(image.Rect(0, 0, 40, 30), image.Point{})[0].img
+	palettes := map[string]color.Palette{
+		"one color":       {color.Black},
+		"black and white": {color.Black, color.White},
+		"web safe":        webSafe(),
+	}
+	algorithms := []DitherAlgorithm{FloydSteinberg, Atkinson, JarvisJudiceNinke, Stucki, Sierra, Bayer2x2, Bayer4x4, Bayer8x8}
+	for name, palette := range palettes {
+		for _, algorithm := range algorithms {
+			t.Run(fmt.Sprintf("%s/%d", name, algorithm), func(t *testing.T) {
+				dithered, err := Dither(img, palette, algorithm)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p, ok := dithered.Image.(*image.Paletted)
+				if !ok {
+					t.Fatalf("dithered a %T, want *image.Paletted", dithered.Image)
+				}
+				if p.Bounds() != img.Bounds() || len(p.Palette) != len(palette) {
+					t.Fatalf("bounds %v and %d colors, want %v and %d", p.Bounds(), len(p.Palette), img.Bounds(), len(palette))
+				}
+				for _, i := range p.Pix {
+					if int(i) >= len(palette) {
+						t.Fatalf("index %d out of a palette of %d colors", i, len(palette))
+					}
+				}
+			})
+		}
+	}
+
+	if _, err := Dither(img, nil, FloydSteinberg); err != ErrInvalidColors {
+		t.Errorf("empty palette: err = %v, want %v", err, ErrInvalidColors)
+	}
+	if _, err := Dither(img, webSafe(), Bayer8x8+1); err != ErrInvalidMethod {
+		t.Errorf("unknown algorithm: err = %v, want %v", err, ErrInvalidMethod)
+	}
+}
+
+func TestDitherKeepsPaletteColors(t *testing.T) {
+	palette := color.Palette{color.Black, color.White, color.NRGBA{0xff, 0, 0, 0xff}}
+	img := image.NewPaletted(image.Rect(0, 0, 16, 16), palette)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i % 3)
+	}
+	for _, algorithm := range []DitherAlgorithm{FloydSteinberg, Atkinson, Bayer4x4} {
+		dithered, err := Dither(img, palette, algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSameNRGBA(t, dithered, img)
+	}
+}
+
+// webSafe returns the 216 colors of the web safe palette.
+func webSafe() color.Palette {
+	var p color.Palette
+	for r := range 6 {
+		for g := range 6 {
+			for b := range 6 {
+				p = append(p, color.NRGBA{uint8(r * 0x33), uint8(g * 0x33), uint8(b * 0x33), 0xff})
+			}
+		}
+	}
+	return p
+}
@@ -36,5 +36,8 @@ Example program:
 	    // Writing the cute gopher
 	    os.WriteFile("gopher.png", buf.Bytes(), 0666)
 	}
+
+Effects work on any image.Rectangle: the result keeps the bounds of the input,
+even when its Min is not (0, 0), like the result of a SubImage or a GIF frame.
 */
 package superimage
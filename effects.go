@@ -2,391 +2,259 @@ package superimage
 
 import (
 	"image"
-	"image/color"
-	"runtime"
-	"sync"
 )
 
-func getWorkers(limit int) (workers, linesPerWorker int) {
-	workers = min(limit, runtime.NumCPU())
-	linesPerWorker = limit / workers
-	return
-}
-
-// Negative inverts the colors of an image.
-func Negative(img image.Image) *SuperImage {
-	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
-	inverted := image.NewNRGBA(bounds)
+// formatOf returns the format of img if it is a SuperImage, png otherwise.
+func formatOf(img image.Image) string {
+	switch sp := img.(type) {
+	case *SuperImage:
+		return sp.Format()
+	case SuperImage:
+		return sp.Format()
+	}
 
-	var wg sync.WaitGroup
-	numWorkers, linesPerWorker := getWorkers(height)
+	return "png"
+}
 
-	for i := range numWorkers {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
+// unwrap returns the image wrapped by a SuperImage, or img itself.
+func unwrap(img image.Image) image.Image {
+	switch sp := img.(type) {
+	case *SuperImage:
+		return sp.Image
+	case SuperImage:
+		return sp.Image
+	}
 
-			startY := workerID * linesPerWorker
-			endY := startY + linesPerWorker
-			if workerID == numWorkers-1 {
-				endY = height
-			}
+	return img
+}
 
-			for y := startY; y < endY; y++ {
-				for x := range width {
-					j := inverted.PixOffset(x, y)
-					p := inverted.Pix[j : j+4 : j+4]
+// metadataOf returns the metadata of img if it is a SuperImage, nil otherwise.
+func metadataOf(img image.Image) *Metadata {
+	switch sp := img.(type) {
+	case *SuperImage:
+		return sp.metadata
+	case SuperImage:
+		return sp.metadata
+	}
 
-					c := img.At(x, y)
-					r, g, b, a := c.RGBA()
+	return nil
+}
 
-					invertedR := 0xFFFF - r
-					invertedG := 0xFFFF - g
-					invertedB := 0xFFFF - b
+// newLike wraps im in a SuperImage with the format and a copy of the metadata of src,
+// so changing the metadata of one image does not change the other.
+func newLike(im, src image.Image) *SuperImage {
+	si := New(im, formatOf(src))
+	si.metadata = metadataOf(src).Clone()
+	return si
+}
 
-					p[0] = uint8(invertedR >> 8)
-					p[1] = uint8(invertedG >> 8)
-					p[2] = uint8(invertedB >> 8)
-					p[3] = uint8(a >> 8)
-				}
-			}
-		}(i)
+// reuseNRGBA returns dst if it covers exactly r, or a new image otherwise.
+func reuseNRGBA(dst *image.NRGBA, r image.Rectangle) *image.NRGBA {
+	if dst != nil && dst.Rect == r {
+		return dst
 	}
-	wg.Wait()
 
-	sp, ok := img.(SuperImage)
-	if ok {
-		return New(inverted, sp.Format())
-	}
+	return image.NewNRGBA(r)
+}
 
-	return New(inverted, "png")
+// Negative inverts the colors of an image.
+func Negative(img image.Image) *SuperImage {
+	inverted, _ := negative(nil, img, nil)
+	return newLike(inverted, img)
 }
 
-// Flip inverts the image horizontally returning a new *SuperImage.
-func Flip(img image.Image) *SuperImage {
+func negative(dst *image.NRGBA, img image.Image, opts *Options) (*image.NRGBA, error) {
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
-	flipped := image.NewRGBA(bounds)
-
-	var wg sync.WaitGroup
-	numWorkers, linesPerWorker := getWorkers(height)
-
-	for i := range numWorkers {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-
-			startY := workerID * linesPerWorker
-			endY := startY + linesPerWorker
-			if workerID == numWorkers-1 {
-				endY = height
-			}
-
-			for y := startY; y < endY; y++ {
-				for x := range width {
-					originalColor := img.At(x, y)
-					flipped.Set(x, height-y-1, originalColor)
-				}
+	inverted := reuseNRGBA(dst, bounds)
+	s := newScanner(img)
+
+	err := parallelFor(opts, height, func(startY, endY int) {
+		for y := bounds.Min.Y + startY; y < bounds.Min.Y+endY; y++ {
+			i := inverted.PixOffset(bounds.Min.X, y)
+			row := inverted.Pix[i : i+width*4 : i+width*4]
+			s.scan(bounds.Min.X, y, bounds.Max.X, row)
+
+			for j := 0; j < len(row); j += 4 {
+				p := row[j : j+3 : j+3]
+				p[0] = 0xff - p[0]
+				p[1] = 0xff - p[1]
+				p[2] = 0xff - p[2]
 			}
-		}(i)
-	}
-	wg.Wait()
+		}
+	})
 
-	sp, ok := img.(SuperImage)
-	if ok {
-		return New(flipped, sp.Format())
-	}
+	return inverted, err
+}
 
-	return New(flipped, "png")
+// Flip inverts the image horizontally returning a new *SuperImage.
+func Flip(img image.Image) *SuperImage {
+	flipped, _ := flip(nil, img, nil)
+	return newLike(flipped, img)
 }
 
-// Reflect inverts the image vertically returning a new *SuperImage.
-func Reflect(img image.Image) *SuperImage {
+func flip(dst *image.NRGBA, img image.Image, opts *Options) (*image.NRGBA, error) {
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
-	reflected := image.NewRGBA(bounds)
+	flipped := reuseNRGBA(dst, bounds)
+	s := newScanner(img)
 
-	var wg sync.WaitGroup
-	numWorkers, linesPerWorker := getWorkers(height)
-
-	for i := range numWorkers {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-
-			startY := workerID * linesPerWorker
-			endY := startY + linesPerWorker
-			if workerID == numWorkers-1 {
-				endY = height
-			}
-
-			for y := startY; y < endY; y++ {
-				for x := range width {
-					originalColor := img.At(x, y)
-					reflected.Set(width-x-1, y, originalColor)
-				}
-			}
-		}(i)
-	}
-	wg.Wait()
+	err := parallelFor(opts, height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			i := flipped.PixOffset(bounds.Min.X, bounds.Max.Y-y-1)
+			s.scan(bounds.Min.X, bounds.Min.Y+y, bounds.Max.X, flipped.Pix[i:i+width*4])
+		}
+	})
 
-	sp, ok := img.(SuperImage)
-	if ok {
-		return New(reflected, sp.Format())
-	}
+	return flipped, err
+}
 
-	return New(reflected, "png")
+// Reflect inverts the image vertically returning a new *SuperImage.
+func Reflect(img image.Image) *SuperImage {
+	reflected, _ := reflect(nil, img, nil)
+	return newLike(reflected, img)
 }
 
-// Blur blurs an image by a given radio.
-// If the radio is negative or bigger than the image's width or height, it returns an error.
-// Radio 0 returns the original image without any change.
-//
-// References: https://relate.cs.illinois.edu/course/cs357-f15/file-version/03473f64afb954c74c02e8988f518de3eddf49a4/media/00-python-numpy/Image%20Blurring.html | http://arantxa.ii.uam.es/~jms/pfcsteleco/lecturas/20081215IreneBlasco.pdf
-func Blur(img image.Image, radius int) (*SuperImage, error) {
+func reflect(dst *image.NRGBA, img image.Image, opts *Options) (*image.NRGBA, error) {
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
-
-	if radius < 0 {
-		return nil, ErrNegativeRadio
-	}
-	blurred := image.NewNRGBA(img.Bounds())
-
-	var wg sync.WaitGroup
-	numWorkers, linesPerWorker := getWorkers(height)
-
-	for i := range numWorkers {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-
-			startY := workerID * linesPerWorker
-			endY := startY + linesPerWorker
-			if workerID == numWorkers-1 {
-				endY = height
-			}
-
-			// Ajusta startY/endY a los límites reales de la imagen
-			if startY < bounds.Min.Y {
-				startY = bounds.Min.Y
-			}
-			if endY > bounds.Max.Y {
-				endY = bounds.Max.Y
+	reflected := reuseNRGBA(dst, bounds)
+	s := newScanner(img)
+
+	err := parallelFor(opts, height, func(startY, endY int) {
+		for y := bounds.Min.Y + startY; y < bounds.Min.Y+endY; y++ {
+			i := reflected.PixOffset(bounds.Min.X, y)
+			row := reflected.Pix[i : i+width*4 : i+width*4]
+			s.scan(bounds.Min.X, y, bounds.Max.X, row)
+
+			for l, r := 0, len(row)-4; l < r; l, r = l+4, r-4 {
+				pl := row[l : l+4 : l+4]
+				pr := row[r : r+4 : r+4]
+				pl[0], pr[0] = pr[0], pl[0]
+				pl[1], pr[1] = pr[1], pl[1]
+				pl[2], pr[2] = pr[2], pl[2]
+				pl[3], pr[3] = pr[3], pl[3]
 			}
+		}
+	})
 
-			for y := startY; y < endY; y++ {
-				for x := bounds.Min.X; x < bounds.Max.X; x++ {
-					var rSum, gSum, bSum, aSum uint64
-					count := 0
-
-					for ky := -radius; ky <= radius; ky++ {
-						for kx := -radius; kx <= radius; kx++ {
-							sampleX, sampleY := x+kx, y+ky
-
-							if sampleX >= bounds.Min.X && sampleX < bounds.Max.X &&
-								sampleY >= bounds.Min.Y && sampleY < bounds.Max.Y {
-								c := img.At(sampleX, sampleY)
-								r, g, b, a := c.RGBA()
-
-								rSum += uint64(r)
-								gSum += uint64(g)
-								bSum += uint64(b)
-								aSum += uint64(a)
-								count++
-							}
-						}
-					}
-
-					var avgR, avgG, avgB, avgA uint32
-					if count > 0 {
-						avgR = uint32(rSum / uint64(count))
-						avgG = uint32(gSum / uint64(count))
-						avgB = uint32(bSum / uint64(count))
-						avgA = uint32(aSum / uint64(count))
-					}
-
-					offset := blurred.PixOffset(x, y)
-					blurred.Pix[offset+0] = uint8(avgR >> 8)
-					blurred.Pix[offset+1] = uint8(avgG >> 8)
-					blurred.Pix[offset+2] = uint8(avgB >> 8)
-					blurred.Pix[offset+3] = uint8(avgA >> 8)
-				}
-			}
-		}(i)
-	}
-	wg.Wait()
-
-	for i := radius; i > 0; i-- {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
-			for x := bounds.Min.X; x < width-1; x++ {
-				for y := bounds.Min.Y; y < height-1; y++ {
-					i := blurred.PixOffset(x, y)
-					p := blurred.Pix[i : i+4 : i+4]
-
-					r1, g1, b1, a1 := blurred.At(x, y).RGBA()
-					r2, g2, b2, a2 := blurred.At(x-1, y).RGBA()
-					r3, g3, b3, a3 := blurred.At(x+1, y).RGBA()
-					r4, g4, b4, a4 := blurred.At(x, y-1).RGBA()
-					r5, g5, b5, a5 := blurred.At(x, y+1).RGBA()
-
-					p[0] = uint8(((r1*4 + r2 + r3 + r4 + r5) / 8) >> 8)
-					p[1] = uint8(((g1*4 + g2 + g3 + g4 + g5) / 8) >> 8)
-					p[2] = uint8(((b1*4 + b2 + b3 + b4 + b5) / 8) >> 8)
-					p[3] = uint8(((a1*4 + a2 + a3 + a4 + a5) / 8) >> 8)
-				}
-			}
-		}(i)
-	}
-	wg.Wait()
-
-	sp, ok := img.(SuperImage)
-	if ok {
-		return New(blurred, sp.Format()), nil
-	}
-
-	return New(blurred, "png"), nil
+	return reflected, err
 }
 
+// Opacity multiplies the alpha channel of an image by op.
+// If op is not between 0 and 1, it returns an error.
 func Opacity(img image.Image, op float64) (*SuperImage, error) {
 	if op > 1 || op < 0 {
 		return nil, ErrInvalidOpacity
 	}
 
+	edited, err := opacity(nil, img, op, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLike(edited, img), nil
+}
+
+func opacity(dst *image.NRGBA, img image.Image, op float64, opts *Options) (*image.NRGBA, error) {
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
-	edited := image.NewNRGBA(bounds)
-
-	var wg sync.WaitGroup
-	numWorkers, linesPerWorker := getWorkers(height)
+	edited := reuseNRGBA(dst, bounds)
+	s := newScanner(img)
 
 	op16bit := uint32(op * 0xFFFF)
 
-	for i := range numWorkers {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
+	err := parallelFor(opts, height, func(startY, endY int) {
+		for y := bounds.Min.Y + startY; y < bounds.Min.Y+endY; y++ {
+			i := edited.PixOffset(bounds.Min.X, y)
+			row := edited.Pix[i : i+width*4 : i+width*4]
+			s.scan(bounds.Min.X, y, bounds.Max.X, row)
 
-			startY := workerID * linesPerWorker
-			endY := startY + linesPerWorker
-			if workerID == numWorkers-1 {
-				endY = height
+			for j := 3; j < len(row); j += 4 {
+				curAlpha := uint32(row[j]) * 0x101
+				newAlpha := curAlpha * op16bit / 0xFFFF
+				row[j] = uint8(newAlpha >> 8)
 			}
+		}
+	})
 
-			for y := startY; y < endY; y++ {
-				for x := range width {
-					_, _, _, curAlpha := img.At(x, y).RGBA()
-					newAlpha := uint32((uint64(curAlpha) * uint64(op16bit)) / 0xFFFF)
-
-					r, g, b, _ := img.At(x, y).RGBA()
+	return edited, err
+}
 
-					offset := edited.PixOffset(x, y)
-					edited.Pix[offset+0] = uint8(r >> 8)
-					edited.Pix[offset+1] = uint8(g >> 8)
-					edited.Pix[offset+2] = uint8(b >> 8)
-					edited.Pix[offset+3] = uint8(newAlpha >> 8)
-				}
-			}
-		}(i)
+// Pixelate pixelates an image using square blocks with radius as side.
+// If the radius is lower than 1, it returns an error.
+func Pixelate(img image.Image, radius int) (*SuperImage, error) {
+	if radius < 1 {
+		return nil, ErrNegativeRadio
 	}
-	wg.Wait()
 
-	sp, ok := img.(SuperImage)
-	if ok {
-		return New(edited, sp.Format()), nil
+	pixelated, err := pixelate(nil, img, radius, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	return New(edited, "png"), nil
+	return newLike(pixelated, img), nil
 }
 
-func Pixelate(img image.Image, radius int) (*SuperImage, error) {
+func pixelate(dst *image.NRGBA, img image.Image, radius int, opts *Options) (*image.NRGBA, error) {
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
-	pixelated := image.NewRGBA(bounds)
+	pixelated := reuseNRGBA(dst, bounds)
+	s := newScanner(img)
 
-	var wg sync.WaitGroup
-
-	numWorkers := runtime.NumCPU()
+	numBlocksX := (width + radius - 1) / radius
 	numBlocksY := (height + radius - 1) / radius
 
-	for i := range numWorkers {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-
-			startBlockY := workerID * (numBlocksY / numWorkers)
-			endBlockY := (workerID + 1) * (numBlocksY / numWorkers)
-			if workerID == numWorkers-1 {
-				endBlockY = numBlocksY
-			}
-
-			startY := startBlockY * radius
-			endY := endBlockY * radius
-			endY = min(endY, height)
+	err := parallelFor(opts, numBlocksY, func(startBlockY, endBlockY int) {
+		row := make([]uint8, width*4)
+		sums := make([][5]uint64, numBlocksX)
 
-			for y := startY; y < endY; y += radius {
-				for x := 0; x < width; x += radius {
-					blockRect := image.Rect(x, y, x+radius, y+radius)
-					blockRect = blockRect.Intersect(bounds)
+		for by := startBlockY; by < endBlockY; by++ {
+			startY := bounds.Min.Y + by*radius
+			endY := min(startY+radius, bounds.Max.Y)
 
-					avgColor := calculateAverageColourWithRect(img, blockRect)
+			// Accumulate every block of this row of blocks, weighting colors by alpha.
+			clear(sums)
+			for y := startY; y < endY; y++ {
+				s.scan(bounds.Min.X, y, bounds.Max.X, row)
+				for x := range width {
+					p := row[x*4 : x*4+4 : x*4+4]
+					a := uint64(p[3])
+					sum := &sums[x/radius]
+					sum[0] += uint64(p[0]) * a
+					sum[1] += uint64(p[1]) * a
+					sum[2] += uint64(p[2]) * a
+					sum[3] += a
+					sum[4]++
+				}
+			}
 
-					for dy := range radius {
-						for dx := range radius {
-							if x+dx < width && y+dy < height {
-								pixelated.Set(x+dx, y+dy, avgColor)
-							}
-						}
+			// Build the averaged row once and copy it to every line of the block.
+			for bx, sum := range sums {
+				var avg [4]uint8
+				if sum[3] > 0 {
+					avg = [4]uint8{
+						uint8(sum[0] / sum[3]),
+						uint8(sum[1] / sum[3]),
+						uint8(sum[2] / sum[3]),
+						uint8(sum[3] / sum[4]),
 					}
 				}
+				for x := bx * radius; x < min((bx+1)*radius, width); x++ {
+					copy(row[x*4:x*4+4], avg[:])
+				}
+			}
+			for y := startY; y < endY; y++ {
+				i := pixelated.PixOffset(bounds.Min.X, y)
+				copy(pixelated.Pix[i:i+width*4], row)
 			}
-		}(i)
-	}
-	wg.Wait()
-
-	sp, ok := img.(*SuperImage)
-	if ok {
-		return New(pixelated, sp.Format()), nil
-	}
-
-	return New(pixelated, "png"), nil
-}
-
-func calculateAverageColourWithRect(img image.Image, rect image.Rectangle) color.Color {
-	var r, g, b, a uint32
-	var count uint32
-
-	imgBounds := img.Bounds()
-	actualRect := rect.Intersect(imgBounds)
-
-	for y := actualRect.Min.Y; y < actualRect.Max.Y; y++ {
-		for x := actualRect.Min.X; x < actualRect.Max.X; x++ {
-			c := img.At(x, y)
-			rr, gg, bb, aa := c.RGBA()
-			r += rr
-			g += gg
-			b += bb
-			a += aa
-			count++
 		}
-	}
+	})
 
-	if count == 0 {
-		return color.RGBA{0, 0, 0, 0}
-	}
-
-	return color.RGBA{
-		R: uint8(r / (count) >> 8),
-		G: uint8(g / (count) >> 8),
-		B: uint8(b / (count) >> 8),
-		A: uint8(a / (count) >> 8),
-	}
+	return pixelated, err
 }
@@ -0,0 +1,133 @@
+package superimage
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// typedImage is a test image named by its type.
+type typedImage struct {
+	name string
+	img  image.Image
+}
+
+// scannerImages returns an image of every type read by the scanner, plus
+// *image.RGBA64 for the generic fallback, covering r. The pixel at (x, y) has
+// the color of a pattern at (x, y) + offset.
+func scannerImages(r image.Rectangle, offset image.Point) []typedImage {
+	pixel := func(x, y int) color.NRGBA {
+		x, y = x+offset.X, y+offset.Y
+		return color.NRGBA{uint8(x * 7), uint8(y * 13), uint8((x + y) * 5), uint8(0x80 + (x*y)%0x80)}
+	}
+	fill := func(img interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}) image.Image {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				img.Set(x, y, pixel(x, y))
+			}
+		}
+		return img
+	}
+
+	ycbcr := image.NewYCbCr(r, image.YCbCrSubsampleRatio444)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := pixel(x, y)
+			yy, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+			ycbcr.Y[ycbcr.YOffset(x, y)] = yy
+			ycbcr.Cb[ycbcr.COffset(x, y)] = cb
+			ycbcr.Cr[ycbcr.COffset(x, y)] = cr
+		}
+	}
+
+	palette := make(color.Palette, 0, 256)
+	for i := range 256 {
+		palette = append(palette, color.NRGBA{uint8(i), uint8(i * 3), uint8(i * 7), uint8(0xff - i/2)})
+	}
+
+	return []typedImage{
+		{"NRGBA", fill(image.NewNRGBA(r))},
+		{"RGBA", fill(image.NewRGBA(r))},
+		{"YCbCr", ycbcr},
+		{"Paletted", fill(image.NewPaletted(r, palette))},
+		{"Gray", fill(image.NewGray(r))},
+		{"generic", fill(image.NewRGBA64(r))},
+	}
+}
+
+func TestEffectsOnSubImage(t *testing.T) {
+	effects := []struct {
+		name  string
+		apply func(image.Image) (*SuperImage, error)
+	}{
+		{"Negative", func(img image.Image) (*SuperImage, error) { return Negative(img), nil }},
+		{"Flip", func(img image.Image) (*SuperImage, error) { return Flip(img), nil }},
+		{"Reflect", func(img image.Image) (*SuperImage, error) { return Reflect(img), nil }},
+		{"Blur", func(img image.Image) (*SuperImage, error) { return Blur(img, 2) }},
+		{"Opacity", func(img image.Image) (*SuperImage, error) { return Opacity(img, 0.5) }},
+		{"Pixelate", func(img image.Image) (*SuperImage, error) { return Pixelate(img, 3) }},
+		{"Grayscale", func(img image.Image) (*SuperImage, error) { return Grayscale(img, GrayRec709) }},
+		{"Sepia", func(img image.Image) (*SuperImage, error) { return Sepia(img, 0.7) }},
+	}
+
+	rect := image.Rect(7, 5, 29, 23)
+	// Copies of the same type with the pixels of rect at the origin.
+	cropped := scannerImages(image.Rect(0, 0, rect.Dx(), rect.Dy()), rect.Min)
+
+	for i, full := range scannerImages(image.Rect(0, 0, 40, 30), image.Point{}) {
+		sub := full.img.(interface {
+			SubImage(image.Rectangle) image.Image
+		}).SubImage(rect)
+
+		for _, e := range effects {
+			t.Run(e.name+"/"+full.name, func(t *testing.T) {
+				got, err := e.apply(sub)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, err := e.apply(cropped[i].img)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got.Bounds() != rect {
+					t.Fatalf("bounds = %v, want %v", got.Bounds(), rect)
+				}
+				for y := range rect.Dy() {
+					for x := range rect.Dx() {
+						g := color.NRGBAModel.Convert(got.At(rect.Min.X+x, rect.Min.Y+y))
+						w := color.NRGBAModel.Convert(want.At(x, y))
+						if g != w {
+							t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkNegative(b *testing.B) {
+	for _, ti := range scannerImages(image.Rect(0, 0, 1024, 768), image.Point{}) {
+		b.Run(ti.name, func(b *testing.B) {
+			for b.Loop() {
+				Negative(ti.img)
+			}
+		})
+	}
+}
+
+func BenchmarkBlur(b *testing.B) {
+	for _, ti := range scannerImages(image.Rect(0, 0, 1024, 768), image.Point{}) {
+		b.Run(ti.name, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := Blur(ti.img, 4); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -11,44 +11,89 @@ import (
 
 var (
 	DefaultPNGEncoder = &png.Encoder{
-		CompressionLevel: png.NoCompression,
+		CompressionLevel: png.DefaultCompression,
 	}
 
 	DefaultEncodeOptions = &EncodeOptions{
-		PngEnc:   DefaultPNGEncoder,
-		JpegOpts: nil,
-		GifOpts:  nil,
+		PngEnc:     DefaultPNGEncoder,
+		PngOpts:    nil,
+		JpegOpts:   nil,
+		GifOpts:    nil,
+		TiffOpts:   nil,
+		NetpbmOpts: nil,
 	}
 )
 
+// FormatAuto is the format that encodes images with transparency as png and
+// opaque images as jpeg.
+const FormatAuto = "auto"
+
 type EncodeOptions struct {
-	PngEnc   *png.Encoder
-	JpegOpts *jpeg.Options
-	GifOpts  *gif.Options
+	// Format overrides the format of the image when not empty. It can be any
+	// registered name or alias, or FormatAuto.
+	Format string
+
+	PngEnc     *png.Encoder
+	PngOpts    *PNGOptions
+	JpegOpts   *jpeg.Options
+	GifOpts    *gif.Options
+	TiffOpts   *TIFFOptions
+	NetpbmOpts *NetpbmOptions
+
+	// StripMetadata drops the metadata of a SuperImage instead of writing it,
+	// for example to remove the location and camera data of a photo before publishing it.
+	StripMetadata bool
 }
 
-// Encode writes the Image m to the given writer in the specified format (png, jpg/jpeg, gif).
+// Encode writes the Image m to the given writer in its format, or in opts.Format if set.
+// The format can be any registered name or alias (png, jpg/jpeg, gif...) or FormatAuto.
 func Encode(w io.Writer, m image.Image, opts *EncodeOptions) error {
 	if opts == nil {
 		opts = DefaultEncodeOptions
 	}
 
-	// Default format to encode
-	format := "png"
+	// Png is the default format to encode.
+	format := formatOf(m)
+	if opts.Format != "" {
+		format = opts.Format
+	}
+	md := metadataOf(m)
+	// Encoders get the wrapped image so they can use its concrete type.
+	m = unwrap(m)
+	if format == FormatAuto {
+		format = autoFormat(m)
+	}
 
-	sp, ok := (m).(SuperImage)
-	if ok {
-		format = sp.Format()
+	f, err := lookupFormat(format)
+	if err != nil {
+		return err
 	}
 
-	switch format {
-	case "png":
-		return opts.PngEnc.Encode(w, m)
-	case "jpg", "jpeg":
-		return jpeg.Encode(w, m, opts.JpegOpts)
-	case "gif":
-		return gif.Encode(w, m, opts.GifOpts)
-	default:
-		return fmt.Errorf("unsupported format: %s", format)
+	if f.encode == nil {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	// Metadata is written for the formats that can hold it.
+	if !opts.StripMetadata && !md.empty() {
+		switch f.name {
+		case "jpeg":
+			// Checked first so nothing is written when it does not fit.
+			if err := checkJPEGMetadata(md); err != nil {
+				return err
+			}
+			w = &jpegMetadataWriter{w: w, md: md}
+		case "png":
+			w = &pngMetadataWriter{w: w, md: md}
+		}
+	}
+
+	return f.encode(w, m, opts)
+}
+
+// autoFormat returns the format used by FormatAuto: png if img has transparency, jpeg otherwise.
+func autoFormat(img image.Image) string {
+	if isOpaque(img) {
+		return "jpeg"
 	}
+	return "png"
 }
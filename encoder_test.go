@@ -0,0 +1,80 @@
+package superimage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEncodeFormat(t *testing.T) {
+	opaque := New(image.NewGray(image.Rect(0, 0, 8, 8)), "png")
+	translucent := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	translucent.SetNRGBA(1, 1, color.NRGBA{0xff, 0, 0, 0x80})
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		format string
+		want   string
+	}{
+		{"own format", opaque, "", "png"},
+		{"override", opaque, "jpeg", "jpeg"},
+		{"alias", opaque, "JPG", "jpeg"},
+		{"auto opaque", opaque, FormatAuto, "jpeg"},
+		{"auto translucent", translucent, FormatAuto, "png"},
+		{"no format", translucent, "", "png"},
+		{"bmp", opaque, "bmp", "bmp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, tt.img, &EncodeOptions{Format: tt.format}); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodeAuto(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Format() != tt.want {
+				t.Fatalf("encoded as %s, want %s", decoded.Format(), tt.want)
+			}
+		})
+	}
+
+	err := Encode(&bytes.Buffer{}, opaque, &EncodeOptions{Format: "unknown"})
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("unknown format: err = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
+
+func TestAs(t *testing.T) {
+	translucent := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	img := New(translucent, "jpeg").WithMetadata(&Metadata{ICCProfile: []byte("profile")})
+
+	tests := map[string]string{
+		"png":      "png",
+		"JPG":      "jpeg",
+		"tif":      "tiff",
+		FormatAuto: "png",
+		"unknown":  "unknown",
+	}
+	for format, want := range tests {
+		if got := img.As(format).Format(); got != want {
+			t.Errorf("As(%q).Format() = %q, want %q", format, got, want)
+		}
+	}
+
+	// The original keeps its format and metadata.
+	converted := img.As("png")
+	converted.Metadata().ICCProfile[0] = 'P'
+	if img.Format() != "jpeg" || string(img.Metadata().ICCProfile) != "profile" {
+		t.Fatalf("the original changed to %s with ICC %q", img.Format(), img.Metadata().ICCProfile)
+	}
+
+	err := Encode(&bytes.Buffer{}, img.As("unknown"), nil)
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("unknown format: err = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
@@ -1,8 +1,51 @@
 package superimage
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
-	ErrNegativeRadio  = errors.New("radio must be higher than 0")
-	ErrInvalidOpacity = errors.New("opacity must be between 0 and 1")
+	ErrNegativeRadio     = errors.New("radio must be higher than 0")
+	ErrInvalidOpacity    = errors.New("opacity must be between 0 and 1")
+	ErrInvalidIntensity  = errors.New("intensity must be between 0 and 1")
+	ErrNegativeSigma     = errors.New("sigma must be finite and not negative")
+	ErrInvalidAngle      = errors.New("angle must be finite")
+	ErrInvalidSize       = errors.New("invalid width or height")
+	ErrBodyTooLarge      = errors.New("response body exceeds the maximum allowed size")
+	ErrUnknownFormat     = errors.New("unknown image format")
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	ErrInvalidImage      = errors.New("invalid image data")
+	ErrNoFrames          = errors.New("animation has no frames")
+	ErrMetadataTooLarge  = errors.New("metadata too large for the format")
+	ErrInvalidQuality    = errors.New("quality must be between 1 and 100")
+	ErrTargetSize        = errors.New("image does not fit in the target size")
+	ErrInvalidColors     = errors.New("number of colors must be between 1 and 256")
+	ErrInvalidMethod     = errors.New("unknown method")
 )
+
+// formatError reports malformed data of the given format. It wraps ErrInvalidImage.
+func formatError(format, reason string) error {
+	return fmt.Errorf("%w: %s: %s", ErrInvalidImage, format, reason)
+}
+
+// HTTPStatusError is returned when a remote image responds with a non-2xx status code.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected status fetching %s: %s", e.URL, e.Status)
+}
+
+// ContentTypeError is returned when a remote image responds with a content type
+// that is not allowed by the Fetcher.
+type ContentTypeError struct {
+	ContentType string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("content type not allowed: %q", e.ContentType)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nicolito128/superimage/v3"
+)
+
+func main() {
+	log.Println("Starting subimage-gopher example...")
+	start := time.Now()
+	defer func() {
+		log.Printf("Time since example started: %dms\n", time.Since(start).Milliseconds())
+	}()
+
+	img, err := superimage.GetByURL("https://go.dev/blog/gopher/gopher.png")
+	if err != nil {
+		panic(err)
+	}
+
+	// Copy the gopher into an image we can draw on.
+	canvas := image.NewNRGBA(img.Bounds())
+	draw.Draw(canvas, canvas.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	// The effects keep the bounds of their input, so the result of an effect
+	// applied to a SubImage can be drawn back at the same place.
+	b := canvas.Bounds()
+	face := image.Rect(b.Min.X+b.Dx()/4, b.Min.Y+b.Dy()/4, b.Max.X-b.Dx()/4, b.Max.Y-b.Dy()/4)
+
+	pxl, err := superimage.Pixelate(canvas.SubImage(face), 12)
+	if err != nil {
+		panic(err)
+	}
+	draw.Draw(canvas, pxl.Bounds(), pxl, pxl.Bounds().Min, draw.Src)
+
+	// Encoding on the buffer
+	buf := new(bytes.Buffer)
+	err = superimage.Encode(buf, superimage.New(canvas, img.Format()), nil)
+	if err != nil {
+		panic(err)
+	}
+
+	// Writing the cute censored gopher
+	file, err := os.Create("examples/subimage/gopher.png")
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	file.Write(buf.Bytes())
+}
@@ -0,0 +1,237 @@
+package superimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// EXIF orientation values, as stored in the Orientation tag (0x0112).
+// Each name is the transformation of this package that displays the image upright.
+const (
+	OrientationUnknown    = 0
+	OrientationNormal     = 1
+	OrientationMirror     = 2
+	OrientationRotate180  = 3
+	OrientationFlip       = 4
+	OrientationTranspose  = 5
+	OrientationRotate270  = 6
+	OrientationTransverse = 7
+	OrientationRotate90   = 8
+)
+
+const (
+	exifOrientationTag = 0x0112
+	exifTypeShort      = 3
+)
+
+// exifHeader starts the APP1 segment that holds EXIF data in a JPEG file.
+var exifHeader = []byte("Exif\x00\x00")
+
+// maxJPEGHeader is the most bytes kept while decoding a JPEG file to read its
+// metadata segments, which come before the image data.
+const maxJPEGHeader = 1 << 20
+
+// jpegSegment is a marker segment of a JPEG file.
+type jpegSegment struct {
+	marker  byte
+	payload []byte
+}
+
+// jpegSegments returns the marker segments of a JPEG file that come before the
+// image data. It stops at the first malformed segment or when data runs out.
+func jpegSegments(data []byte) []jpegSegment {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+
+	var segments []jpegSegment
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			break
+		}
+
+		// Markers can be preceded by any number of fill bytes.
+		for i+1 < len(data) && data[i+1] == 0xff {
+			i++
+		}
+		if i+1 >= len(data) {
+			break
+		}
+
+		marker := data[i+1]
+		i += 2
+
+		// Markers without a payload.
+		if marker == 0x01 || marker >= 0xd0 && marker <= 0xd7 {
+			continue
+		}
+		// Start of scan or end of image: the metadata is over.
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+
+		if i+2 > len(data) {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			break
+		}
+
+		segments = append(segments, jpegSegment{marker: marker, payload: data[i+2 : i+length]})
+		i += length
+	}
+
+	return segments
+}
+
+// jpegEXIF returns the TIFF structure stored in the EXIF APP1 segment of a JPEG file, if any.
+func jpegEXIF(data []byte) []byte {
+	for _, s := range jpegSegments(data) {
+		if s.marker == 0xe1 && bytes.HasPrefix(s.payload, exifHeader) {
+			return s.payload[len(exifHeader):]
+		}
+	}
+
+	return nil
+}
+
+// exifOrientation reads the Orientation tag of the first IFD of an EXIF TIFF structure.
+// It returns OrientationUnknown if the tag is missing or malformed.
+func exifOrientation(tiff []byte) int {
+	off, ok := exifOrientationOffset(tiff)
+	if !ok {
+		return OrientationUnknown
+	}
+
+	v := int(exifByteOrder(tiff).Uint16(tiff[off:]))
+	if v < OrientationNormal || v > OrientationRotate90 {
+		return OrientationUnknown
+	}
+
+	return v
+}
+
+// exifOrientationOffset returns where the value of the Orientation tag is stored in tiff.
+func exifOrientationOffset(tiff []byte) (int, bool) {
+	if len(tiff) < 8 {
+		return 0, false
+	}
+
+	order := exifByteOrder(tiff)
+	if order == nil || order.Uint16(tiff[2:]) != 42 {
+		return 0, false
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0, false
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := range count {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0, false
+		}
+
+		if order.Uint16(tiff[entry:]) == exifOrientationTag && order.Uint16(tiff[entry+2:]) == exifTypeShort {
+			return entry + 8, true
+		}
+	}
+
+	return 0, false
+}
+
+// resetOrientation returns a copy of tiff with its Orientation tag set to normal.
+func resetOrientation(tiff []byte) []byte {
+	off, ok := exifOrientationOffset(tiff)
+	if !ok {
+		return tiff
+	}
+
+	tiff = bytes.Clone(tiff)
+	exifByteOrder(tiff).PutUint16(tiff[off:], OrientationNormal)
+	return tiff
+}
+
+// exifByteOrder returns the byte order declared by a TIFF header, or nil.
+func exifByteOrder(tiff []byte) binary.ByteOrder {
+	switch {
+	case bytes.HasPrefix(tiff, []byte("II")):
+		return binary.LittleEndian
+	case bytes.HasPrefix(tiff, []byte("MM")):
+		return binary.BigEndian
+	default:
+		return nil
+	}
+}
+
+// applyOrientation transforms img so it is displayed upright for the given EXIF orientation.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case OrientationMirror:
+		return Reflect(img).Image
+	case OrientationRotate180:
+		return Rotate180(img).Image
+	case OrientationFlip:
+		return Flip(img).Image
+	case OrientationTranspose:
+		return Transpose(img).Image
+	case OrientationRotate270:
+		return Rotate270(img).Image
+	case OrientationTransverse:
+		return Transverse(img).Image
+	case OrientationRotate90:
+		return Rotate90(img).Image
+	default:
+		return img
+	}
+}
+
+// jpegHead is an io.Writer that keeps the start of a JPEG file written to it, up
+// to the first marker that is not an APPn segment, where the metadata is over,
+// or up to maxJPEGHeader bytes.
+type jpegHead struct {
+	buf []byte
+	// next is the offset in buf of the next marker.
+	next int
+	done bool
+}
+
+func (h *jpegHead) Write(p []byte) (int, error) {
+	if h.done {
+		return len(p), nil
+	}
+
+	h.buf = append(h.buf, p...)
+	if h.next == 0 && len(h.buf) >= 2 {
+		h.next = 2
+	}
+	for h.next > 0 && h.next+4 <= len(h.buf) {
+		i := h.next
+		// Markers can be preceded by any number of fill bytes.
+		if h.buf[i] == 0xff && h.buf[i+1] == 0xff {
+			h.next++
+			continue
+		}
+		if h.buf[i] != 0xff || h.buf[i+1] < 0xe0 || h.buf[i+1] > 0xef {
+			h.stop(i)
+			break
+		}
+		h.next = i + 2 + int(binary.BigEndian.Uint16(h.buf[i+2:]))
+	}
+	if len(h.buf) > maxJPEGHeader {
+		h.stop(maxJPEGHeader)
+	}
+
+	return len(p), nil
+}
+
+// stop keeps the first n bytes and ignores the next writes.
+func (h *jpegHead) stop(n int) {
+	h.buf = h.buf[:n]
+	h.done = true
+}
@@ -0,0 +1,112 @@
+package superimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// exifWithOrientation returns a big-endian EXIF TIFF structure with only the
+// Orientation tag.
+func exifWithOrientation(orientation int) []byte {
+	b := []byte("MM\x00*")
+	b = binary.BigEndian.AppendUint32(b, 8)
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = binary.BigEndian.AppendUint16(b, exifOrientationTag)
+	b = binary.BigEndian.AppendUint16(b, exifTypeShort)
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(orientation))
+	b = binary.BigEndian.AppendUint16(b, 0)
+	return binary.BigEndian.AppendUint32(b, 0)
+}
+
+func TestApplyOrientation(t *testing.T) {
+	const w, h = 5, 3
+	src := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+
+	// The pixel of src shown at (x, y) of the upright image, as the EXIF
+	// specification describes every orientation.
+	tests := map[int]func(x, y int) (int, int){
+		OrientationNormal:     func(x, y int) (int, int) { return x, y },
+		OrientationMirror:     func(x, y int) (int, int) { return w - 1 - x, y },
+		OrientationRotate180:  func(x, y int) (int, int) { return w - 1 - x, h - 1 - y },
+		OrientationFlip:       func(x, y int) (int, int) { return x, h - 1 - y },
+		OrientationTranspose:  func(x, y int) (int, int) { return y, x },
+		OrientationRotate270:  func(x, y int) (int, int) { return y, h - 1 - x },
+		OrientationTransverse: func(x, y int) (int, int) { return w - 1 - y, h - 1 - x },
+		OrientationRotate90:   func(x, y int) (int, int) { return w - 1 - y, x },
+	}
+	for orientation, from := range tests {
+		got := applyOrientation(src, orientation)
+		b := got.Bounds()
+		if orientation >= OrientationTranspose && (b.Dx() != h || b.Dy() != w) {
+			t.Fatalf("orientation %d: size = %v, want %dx%d", orientation, b.Size(), h, w)
+		}
+		for y := range b.Dy() {
+			for x := range b.Dx() {
+				sx, sy := from(x, y)
+				g := color.GrayModel.Convert(got.At(b.Min.X+x, b.Min.Y+y))
+				if want := src.GrayAt(sx, sy); g != want {
+					t.Fatalf("orientation %d: pixel (%d, %d) = %v, want %v", orientation, x, y, g, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeJPEGOrientation(t *testing.T) {
+	img := New(image.NewGray(image.Rect(0, 0, 32, 16)), "jpeg").WithMetadata(&Metadata{EXIF: exifWithOrientation(OrientationRotate270)})
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &EncodeOptions{Format: "jpeg"}); err != nil {
+		t.Fatal(err)
+	}
+
+	upright, err := Decode(bytes.NewReader(buf.Bytes()), "jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upright.Orientation() != OrientationRotate270 || upright.Bounds().Size() != image.Pt(16, 32) {
+		t.Fatalf("orientation %d, size %v, want %d and (16,32)", upright.Orientation(), upright.Bounds().Size(), OrientationRotate270)
+	}
+	// The stored EXIF must not rotate the upright pixels again.
+	if o := exifOrientation(upright.Metadata().EXIF); o != OrientationNormal {
+		t.Fatalf("orientation of the EXIF kept = %d, want %d", o, OrientationNormal)
+	}
+
+	stored, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{Format: "jpeg", IgnoreOrientation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Bounds().Size() != image.Pt(32, 16) {
+		t.Fatalf("size ignoring the orientation = %v, want (32,16)", stored.Bounds().Size())
+	}
+}
+
+func TestJPEGHeadStopsAtImageData(t *testing.T) {
+	img := New(scannerImages(image.Rect(0, 0, 256, 256), image.Point{})[0].img, "jpeg").
+		WithMetadata(&Metadata{EXIF: exifWithOrientation(OrientationNormal), XMP: []byte("<x/>")})
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &EncodeOptions{Format: "jpeg"}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Written in small pieces, like a decoder reading through a tee.
+	head := &jpegHead{}
+	for p := data; len(p) > 0; p = p[min(len(p), 100):] {
+		head.Write(p[:min(len(p), 100)])
+	}
+
+	segments := jpegSegments(head.buf)
+	if len(segments) != 2 || segments[0].marker != 0xe1 || segments[1].marker != 0xe1 {
+		t.Fatalf("kept %d segments, want the EXIF and XMP APP1 segments", len(segments))
+	}
+	if len(head.buf) >= len(data)/2 {
+		t.Fatalf("kept %d of %d bytes, want only the APPn segments", len(head.buf), len(data))
+	}
+}
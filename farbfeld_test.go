@@ -0,0 +1,60 @@
+package superimage
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFarbfeldRoundTrip(t *testing.T) {
+	r := image.Rect(3, 2, 40, 21)
+	for _, ti := range scannerImages(r, image.Point{}) {
+		t.Run(ti.name, func(t *testing.T) {
+			got := roundTrip(t, ti.img, &EncodeOptions{Format: "farbfeld"})
+			assertSameNRGBA(t, got, ti.img)
+		})
+	}
+
+	wide := image.NewNRGBA64(r)
+	gray16 := image.NewGray16(r)
+	cmyk := image.NewCMYK(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			wide.SetNRGBA64(x, y, color.NRGBA64{uint16(x * 1733), uint16(y * 3001), 0x1234, uint16(x * y * 97)})
+			gray16.SetGray16(x, y, color.Gray16{uint16(x*1733 + y)})
+			cmyk.SetCMYK(x, y, color.CMYK{uint8(x * 5), uint8(y * 9), 0x30, 0x10})
+		}
+	}
+	for _, ti := range []typedImage{{"NRGBA64", wide}, {"Gray16", gray16}} {
+		t.Run(ti.name, func(t *testing.T) {
+			got := roundTrip(t, ti.img, &EncodeOptions{Format: "farbfeld"})
+			assertSame64(t, translate(got.Image, r.Min), ti.img)
+		})
+	}
+
+	// CMYK is 8-bit, so it is scanned like the other 8-bit types.
+	t.Run("CMYK", func(t *testing.T) {
+		got := roundTrip(t, cmyk, &EncodeOptions{Format: "farbfeld"})
+		assertSameNRGBA(t, got, cmyk)
+	})
+}
+
+func TestFarbfeldRejectsTruncated(t *testing.T) {
+	header := func(width, height uint32) []byte {
+		b := []byte("farbfeld")
+		b = binary.BigEndian.AppendUint32(b, width)
+		return binary.BigEndian.AppendUint32(b, height)
+	}
+	tests := map[string][]byte{
+		"header only":  header(16000, 16000),
+		"short header": header(16000, 16000)[:12],
+		"short pixels": append(header(2, 2), make([]byte, 31)...),
+		"too big":      header(1<<20, 1<<20),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertRejected(t, "farbfeld", data)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package superimage
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultFetcher is the Fetcher used by GetByURL and GetByURLContext.
+var DefaultFetcher = &Fetcher{}
+
+// Fetcher gets images from remote locations with an http GET request.
+// The zero value is ready to use and behaves like http.DefaultClient with no limits.
+type Fetcher struct {
+	// Client used to perform the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// MaxBytes is the maximum number of bytes read from the response body.
+	// Zero or negative means no limit.
+	MaxBytes int64
+
+	// AllowedContentTypes restricts the accepted response media types, e.g. "image/png".
+	// A trailing wildcard such as "image/*" matches every subtype. Empty allows any type.
+	AllowedContentTypes []string
+
+	// Header holds extra headers sent with every request.
+	Header http.Header
+}
+
+// GetByURLContext gets an image from an URL using the DefaultFetcher.
+// The request is aborted when ctx is cancelled.
+func GetByURLContext(ctx context.Context, link string) (*SuperImage, error) {
+	return DefaultFetcher.Fetch(ctx, link)
+}
+
+// Fetch performs an http GET request to link and decodes the response body.
+// The format is detected from the body content, not from the URL.
+// Non-2xx responses are returned as *HTTPStatusError.
+func (f *Fetcher) Fetch(ctx context.Context, link string) (*SuperImage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "image/*")
+	for key, values := range f.Header {
+		req.Header.Del(key)
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &HTTPStatusError{
+			URL:        link,
+			StatusCode: res.StatusCode,
+			Status:     res.Status,
+		}
+	}
+
+	if err := f.checkContentType(res.Header.Get("Content-Type")); err != nil {
+		return nil, err
+	}
+
+	var body io.Reader = res.Body
+	if f.MaxBytes > 0 {
+		if res.ContentLength > f.MaxBytes {
+			return nil, ErrBodyTooLarge
+		}
+		body = &limitedReader{r: res.Body, n: f.MaxBytes}
+	}
+
+	return DecodeAuto(body)
+}
+
+// checkContentType validates the response media type against AllowedContentTypes.
+func (f *Fetcher) checkContentType(contentType string) error {
+	if len(f.AllowedContentTypes) == 0 {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return &ContentTypeError{ContentType: contentType}
+	}
+
+	for _, allowed := range f.AllowedContentTypes {
+		allowed = strings.ToLower(allowed)
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return nil
+			}
+			continue
+		}
+
+		if mediaType == allowed {
+			return nil
+		}
+	}
+
+	return &ContentTypeError{ContentType: contentType}
+}
+
+// limitedReader works like io.LimitedReader but reports ErrBodyTooLarge
+// instead of a silent EOF when the limit is exceeded.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrBodyTooLarge
+	}
+
+	// Read one extra byte so a body of exactly n bytes is still accepted.
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, ErrBodyTooLarge
+	}
+
+	return n, err
+}
@@ -0,0 +1,159 @@
+package superimage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Gravity anchors the part of an image kept by Fill, or the place of the image inside Pad.
+type Gravity int
+
+const (
+	GravityCenter Gravity = iota
+	GravityNorth
+	GravityNorthEast
+	GravityEast
+	GravitySouthEast
+	GravitySouth
+	GravitySouthWest
+	GravityWest
+	GravityNorthWest
+)
+
+// offset returns where a box of free space (dx, dy) is split by the gravity.
+func (g Gravity) offset(dx, dy int) image.Point {
+	var p image.Point
+
+	switch g {
+	case GravityNorthWest, GravityWest, GravitySouthWest:
+		p.X = 0
+	case GravityNorthEast, GravityEast, GravitySouthEast:
+		p.X = dx
+	default:
+		p.X = dx / 2
+	}
+
+	switch g {
+	case GravityNorthWest, GravityNorth, GravityNorthEast:
+		p.Y = 0
+	case GravitySouthWest, GravitySouth, GravitySouthEast:
+		p.Y = dy
+	default:
+		p.Y = dy / 2
+	}
+
+	return p
+}
+
+// fitSize returns the biggest size with the aspect ratio of src that fits in width x height.
+func fitSize(src image.Rectangle, width, height int) (int, int) {
+	srcW, srcH := float64(src.Dx()), float64(src.Dy())
+	scale := math.Min(float64(width)/srcW, float64(height)/srcH)
+
+	return max(1, int(math.Round(srcW*scale))), max(1, int(math.Round(srcH*scale)))
+}
+
+// Fit scales down an image to fit inside width x height keeping its aspect ratio.
+// Images that already fit are copied without being enlarged. A zero width or height
+// leaves that side unbounded. The result bounds start at (0, 0).
+func Fit(img image.Image, width, height int, filter ResampleFilter) (*SuperImage, error) {
+	if width < 0 || height < 0 || width == 0 && height == 0 {
+		return nil, ErrInvalidSize
+	}
+
+	bounds := img.Bounds()
+	if width == 0 {
+		width = bounds.Dx()
+	}
+	if height == 0 {
+		height = bounds.Dy()
+	}
+
+	if bounds.Empty() || bounds.Dx() <= width && bounds.Dy() <= height {
+		return Crop(img, bounds), nil
+	}
+
+	w, h := fitSize(bounds, width, height)
+	return Resize(img, w, h, filter)
+}
+
+// Thumbnail is a Fit with the CatmullRom filter, a good balance between speed and sharpness.
+func Thumbnail(img image.Image, width, height int) (*SuperImage, error) {
+	return Fit(img, width, height, CatmullRom)
+}
+
+// Fill scales an image to cover width x height keeping its aspect ratio and crops
+// the overflow. The gravity chooses which part of the image is kept.
+// The result bounds start at (0, 0).
+func Fill(img image.Image, width, height int, gravity Gravity, filter ResampleFilter) (*SuperImage, error) {
+	if width <= 0 || height <= 0 {
+		return nil, ErrInvalidSize
+	}
+
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return Crop(img, bounds), nil
+	}
+
+	// Crop the source to the aspect ratio of the box first, so only the kept pixels are resampled.
+	srcW, srcH := float64(bounds.Dx()), float64(bounds.Dy())
+	scale := math.Max(float64(width)/srcW, float64(height)/srcH)
+	cropW := min(bounds.Dx(), max(1, int(math.Round(float64(width)/scale))))
+	cropH := min(bounds.Dy(), max(1, int(math.Round(float64(height)/scale))))
+
+	origin := bounds.Min.Add(gravity.offset(bounds.Dx()-cropW, bounds.Dy()-cropH))
+	rect := image.Rectangle{Min: origin, Max: origin.Add(image.Pt(cropW, cropH))}
+
+	return Resize(subImage(img, rect), width, height, filter)
+}
+
+// Pad scales an image to fit inside width x height keeping its aspect ratio, enlarging
+// it if needed, and fills the rest of the box with bg. The gravity places the image
+// inside the box. The result bounds start at (0, 0).
+func Pad(img image.Image, width, height int, bg color.Color, gravity Gravity, filter ResampleFilter) (*SuperImage, error) {
+	if width <= 0 || height <= 0 {
+		return nil, ErrInvalidSize
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	if bg != nil {
+		draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return newLike(canvas, img), nil
+	}
+
+	w, h := fitSize(bounds, width, height)
+	resized, err := Resize(img, w, h, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	at := gravity.offset(width-w, height-h)
+	draw.Draw(canvas, resized.Bounds().Add(at), resized.Image, image.Point{}, draw.Over)
+
+	return newLike(canvas, img), nil
+}
+
+// Letterbox is a Pad centered in the box.
+func Letterbox(img image.Image, width, height int, bg color.Color, filter ResampleFilter) (*SuperImage, error) {
+	return Pad(img, width, height, bg, GravityCenter, filter)
+}
+
+// subImage returns the part of img inside rect without copying it when
+// the image supports SubImage. The format and metadata of a SuperImage are kept.
+func subImage(img image.Image, rect image.Rectangle) image.Image {
+	type subImager interface {
+		SubImage(r image.Rectangle) image.Image
+	}
+
+	if sub, ok := unwrap(img).(subImager); ok {
+		return newLike(sub.SubImage(rect), img)
+	}
+
+	return newLike(Crop(img, rect).Image, img)
+}
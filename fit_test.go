@@ -0,0 +1,143 @@
+package superimage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFitDimensions(t *testing.T) {
+	img := scannerImages(image.Rect(-10, 4, 190, 104), image.Point{})[0].img
+	tests := []struct {
+		width, height int
+		want          image.Point
+	}{
+		{100, 100, image.Pt(100, 50)},
+		{100, 20, image.Pt(40, 20)},
+		{50, 0, image.Pt(50, 25)},
+		{0, 10, image.Pt(20, 10)},
+		// Images that already fit are not enlarged.
+		{400, 400, image.Pt(200, 100)},
+		{1, 1, image.Pt(1, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%dx%d", tt.width, tt.height), func(t *testing.T) {
+			fit, err := Fit(img, tt.width, tt.height, Bilinear)
+			if err != nil {
+				t.Fatal(err)
+			}
+			thumb, err := Thumbnail(img, tt.width, tt.height)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, got := range []*SuperImage{fit, thumb} {
+				if got.Bounds() != (image.Rectangle{Max: tt.want}) {
+					t.Fatalf("bounds = %v, want size %v from (0,0)", got.Bounds(), tt.want)
+				}
+			}
+		})
+	}
+
+	if _, err := Fit(img, 0, 0, Bilinear); err != ErrInvalidSize {
+		t.Errorf("Fit to 0x0: err = %v, want %v", err, ErrInvalidSize)
+	}
+}
+
+func TestFill(t *testing.T) {
+	// Left half black and right half white.
+	img := image.NewGray(image.Rect(3, 3, 203, 103))
+	for y := 3; y < 103; y++ {
+		for x := 103; x < 203; x++ {
+			img.SetGray(x, y, color.Gray{0xff})
+		}
+	}
+
+	tests := []struct {
+		gravity Gravity
+		// The gray level of the result, which shows the half that was kept.
+		want uint8
+	}{
+		{GravityWest, 0},
+		{GravityNorthWest, 0},
+		{GravityEast, 0xff},
+		{GravitySouthEast, 0xff},
+	}
+	for _, tt := range tests {
+		got, err := Fill(img, 50, 50, tt.gravity, Bilinear)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Bounds() != image.Rect(0, 0, 50, 50) {
+			t.Fatalf("gravity %d: bounds = %v, want (0,0)-(50,50)", tt.gravity, got.Bounds())
+		}
+		for _, p := range []image.Point{{0, 0}, {49, 49}, {25, 25}} {
+			if g := color.GrayModel.Convert(got.At(p.X, p.Y)).(color.Gray).Y; g != tt.want {
+				t.Fatalf("gravity %d: pixel %v = %d, want %d", tt.gravity, p, g, tt.want)
+			}
+		}
+	}
+
+	// Enlarging fills the box too.
+	got, err := Fill(img, 300, 600, GravityCenter, Bilinear)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Bounds() != image.Rect(0, 0, 300, 600) {
+		t.Fatalf("bounds = %v, want (0,0)-(300,600)", got.Bounds())
+	}
+
+	if _, err := Fill(img, 0, 10, GravityCenter, Bilinear); err != ErrInvalidSize {
+		t.Errorf("Fill to 0x10: err = %v, want %v", err, ErrInvalidSize)
+	}
+}
+
+func TestPad(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 40, 20))
+	bg := color.NRGBA{0xff, 0, 0, 0xff}
+
+	tests := []struct {
+		gravity Gravity
+		width   int
+		height  int
+		// The rectangle covered by the image.
+		want image.Rectangle
+	}{
+		{GravityCenter, 100, 100, image.Rect(0, 25, 100, 75)},
+		{GravityNorth, 100, 100, image.Rect(0, 0, 100, 50)},
+		{GravitySouth, 100, 100, image.Rect(0, 50, 100, 100)},
+		{GravityWest, 60, 10, image.Rect(0, 0, 20, 10)},
+		{GravityEast, 60, 10, image.Rect(40, 0, 60, 10)},
+	}
+	for _, tt := range tests {
+		got, err := Pad(img, tt.width, tt.height, bg, tt.gravity, Bilinear)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Bounds() != image.Rect(0, 0, tt.width, tt.height) {
+			t.Fatalf("gravity %d: bounds = %v, want (0,0)-(%d,%d)", tt.gravity, got.Bounds(), tt.width, tt.height)
+		}
+		for y := range tt.height {
+			for x := range tt.width {
+				want := bg
+				if image.Pt(x, y).In(tt.want) {
+					want = color.NRGBA{0, 0, 0, 0xff}
+				}
+				if c := color.NRGBAModel.Convert(got.At(x, y)); c != want {
+					t.Fatalf("gravity %d: pixel (%d, %d) = %v, want %v", tt.gravity, x, y, c, want)
+				}
+			}
+		}
+	}
+
+	got, err := Letterbox(img, 100, 100, bg, Bilinear)
+	if err != nil {
+		t.Fatal(err)
+	}
+	centered, _ := Pad(img, 100, 100, bg, GravityCenter, Bilinear)
+	assertSameNRGBA(t, got, centered)
+
+	if _, err := Pad(img, 10, -1, bg, GravityCenter, Bilinear); err != ErrInvalidSize {
+		t.Errorf("Pad to 10x-1: err = %v, want %v", err, ErrInvalidSize)
+	}
+}
@@ -0,0 +1,211 @@
+package superimage
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// DecodeFunc decodes an image from a reader.
+type DecodeFunc func(r io.Reader) (image.Image, error)
+
+// EncodeFunc writes an image to a writer. opts is never nil.
+type EncodeFunc func(w io.Writer, m image.Image, opts *EncodeOptions) error
+
+// imageFormat describes how to recognize, decode and encode an image format.
+type imageFormat struct {
+	// Canonical name stored in SuperImage.format.
+	name string
+	// Other names accepted by Decode and Encode, e.g. "jpg" for "jpeg".
+	aliases []string
+	// Magic prefixes that identify the format. '?' matches any byte.
+	magic []string
+	// Decoder and encoder functions. Either may be nil.
+	decode DecodeFunc
+	encode EncodeFunc
+	// Functions used by DecodeAll and EncodeAll for formats that can hold animations.
+	decodeAll func(r io.Reader) (*AnimatedImage, error)
+	encodeAll func(w io.Writer, a *AnimatedImage, opts *EncodeOptions) error
+}
+
+// registry holds the known formats. It is safe for concurrent use.
+var registry = struct {
+	sync.RWMutex
+	formats []*imageFormat
+	byName  map[string]*imageFormat
+}{
+	byName: make(map[string]*imageFormat),
+}
+
+func init() {
+	RegisterFormat("png", []string{"apng"}, []string{"\x89PNG\r\n\x1a\n"}, png.Decode, encodePNG)
+	RegisterFormat("jpeg", []string{"jpg"}, []string{"\xff\xd8"}, jpeg.Decode, encodeJPEG)
+	RegisterFormat("gif", nil, []string{"GIF87a", "GIF89a"}, gif.Decode, encodeGIF)
+	RegisterFormat("bmp", nil, []string{"BM"}, decodeBMP, encodeBMP)
+	RegisterFormat("tiff", []string{"tif"}, []string{"II*\x00", "MM\x00*"}, decodeTIFF, encodeTIFF)
+	RegisterFormat("ico", nil, []string{"\x00\x00\x01\x00"}, decodeICO, encodeICO)
+	RegisterFormat("cur", nil, []string{"\x00\x00\x02\x00"}, decodeICO, encodeCUR)
+	RegisterFormat("qoi", nil, []string{"qoif"}, decodeQOI, encodeQOI)
+	RegisterFormat("farbfeld", []string{"ff"}, []string{"farbfeld"}, decodeFarbfeld, encodeFarbfeld)
+	RegisterFormat("pbm", nil, []string{"P1", "P4"}, decodeNetpbm, netpbmEncoder("pbm"))
+	RegisterFormat("pgm", nil, []string{"P2", "P5"}, decodeNetpbm, netpbmEncoder("pgm"))
+	RegisterFormat("ppm", []string{"pnm"}, []string{"P3", "P6"}, decodeNetpbm, netpbmEncoder("ppm"))
+	RegisterFormat("pam", nil, []string{"P7"}, decodeNetpbm, netpbmEncoder("pam"))
+
+	registerAnimation("png", decodeAllPNG, encodeAllPNG)
+	registerAnimation("gif", decodeAllGIF, encodeAllGIF)
+}
+
+// RegisterFormat registers an image format for use by Decode, DecodeAuto, Encode and GetByFile.
+// Name and aliases are case-insensitive. Each magic string is a prefix that identifies
+// the encoded data, '?' matching any byte. Either decoder or encoder can be nil.
+// Registering a name again replaces the previous format with that name, but keeps
+// its animation support, so DecodeAll and EncodeAll still work with it.
+func RegisterFormat(name string, aliases []string, magic []string, decoder DecodeFunc, encoder EncodeFunc) {
+	f := &imageFormat{
+		name:   strings.ToLower(name),
+		magic:  slices.Clone(magic),
+		decode: decoder,
+		encode: encoder,
+	}
+	for _, alias := range aliases {
+		f.aliases = append(f.aliases, strings.ToLower(alias))
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.formats = slices.DeleteFunc(registry.formats, func(old *imageFormat) bool {
+		if old.name != f.name {
+			return false
+		}
+		f.decodeAll, f.encodeAll = old.decodeAll, old.encodeAll
+		return true
+	})
+	registry.formats = append(registry.formats, f)
+
+	clear(registry.byName)
+	for _, f := range registry.formats {
+		for _, alias := range f.aliases {
+			registry.byName[alias] = f
+		}
+	}
+	for _, f := range registry.formats {
+		registry.byName[f.name] = f
+	}
+}
+
+// registerAnimation adds the animation support of a registered format.
+func registerAnimation(name string, decodeAll func(io.Reader) (*AnimatedImage, error), encodeAll func(io.Writer, *AnimatedImage, *EncodeOptions) error) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if f, ok := registry.byName[name]; ok {
+		f.decodeAll = decodeAll
+		f.encodeAll = encodeAll
+	}
+}
+
+// Formats returns the sorted canonical names of the registered formats.
+func Formats() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	names := make([]string, 0, len(registry.formats))
+	for _, f := range registry.formats {
+		names = append(names, f.name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// lookupFormat finds a registered format by its name or one of its aliases.
+func lookupFormat(name string) (*imageFormat, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	f, ok := registry.byName[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, name)
+	}
+
+	return f, nil
+}
+
+// peeker is a reader that can look ahead without consuming the input.
+type peeker interface {
+	io.Reader
+	Peek(int) ([]byte, error)
+}
+
+// asPeeker wraps r in a bufio.Reader if it cannot peek by itself.
+func asPeeker(r io.Reader) peeker {
+	if p, ok := r.(peeker); ok {
+		return p
+	}
+	return bufio.NewReader(r)
+}
+
+// matchMagic reports whether b starts with magic, '?' being a wildcard.
+func matchMagic(magic string, b []byte) bool {
+	if len(magic) != len(b) {
+		return false
+	}
+
+	for i, c := range b {
+		if magic[i] != c && magic[i] != '?' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sniff looks at the first bytes of r to find its format.
+func sniff(r peeker) (*imageFormat, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	for _, f := range registry.formats {
+		if f.decode == nil {
+			continue
+		}
+
+		for _, magic := range f.magic {
+			b, err := r.Peek(len(magic))
+			if err == nil && matchMagic(magic, b) {
+				return f, nil
+			}
+		}
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+func encodePNG(w io.Writer, m image.Image, opts *EncodeOptions) error {
+	if opts.PngOpts != nil {
+		return encodeOptimizedPNG(w, m, opts)
+	}
+	if opts.PngEnc == nil {
+		return png.Encode(w, m)
+	}
+	return opts.PngEnc.Encode(w, m)
+}
+
+func encodeJPEG(w io.Writer, m image.Image, opts *EncodeOptions) error {
+	return jpeg.Encode(w, m, opts.JpegOpts)
+}
+
+// encodeGIF quantizes m like the frames of an animation, so it keeps its colors
+// when they fit and uses MedianCut and a transparent color otherwise.
+func encodeGIF(w io.Writer, m image.Image, opts *EncodeOptions) error {
+	return gif.Encode(w, toPaletted(m, opts.GifOpts, nil), opts.GifOpts)
+}
@@ -0,0 +1,85 @@
+package superimage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"slices"
+	"testing"
+)
+
+// registerTestFormat registers "test", whose files are "TST" followed by the width
+// of a single-row gray image.
+func registerTestFormat() {
+	RegisterFormat("test", []string{"tst"}, []string{"TST?"},
+		func(r io.Reader) (image.Image, error) {
+			b := make([]byte, 4)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return nil, err
+			}
+			return image.NewGray(image.Rect(0, 0, int(b[3]), 1)), nil
+		},
+		func(w io.Writer, m image.Image, _ *EncodeOptions) error {
+			_, err := w.Write([]byte{'T', 'S', 'T', byte(m.Bounds().Dx())})
+			return err
+		})
+}
+
+func TestRegisterFormat(t *testing.T) {
+	registerTestFormat()
+	if !slices.Contains(Formats(), "test") {
+		t.Fatalf("Formats() = %v, want it to contain test", Formats())
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, image.NewGray(image.Rect(0, 0, 7, 3)), &EncodeOptions{Format: "TST"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "TST\x07" {
+		t.Fatalf("encoded %q, want %q", got, "TST\x07")
+	}
+
+	img, err := DecodeAuto(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Format() != "test" || img.Bounds().Dx() != 7 {
+		t.Fatalf("decoded a %s image of width %d, want test of width 7", img.Format(), img.Bounds().Dx())
+	}
+
+	if _, err := Decode(bytes.NewReader(nil), "unknown"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("unknown format: err = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
+
+func TestRegisterFormatKeepsAnimation(t *testing.T) {
+	// Replace the gif decoder and encoder by the same ones.
+	RegisterFormat("gif", nil, []string{"GIF87a", "GIF89a"}, gif.Decode, encodeGIF)
+
+	a := &AnimatedImage{
+		Frames: []image.Image{
+			image.NewPaletted(image.Rect(0, 0, 4, 4), paletteOf(0)),
+			image.NewPaletted(image.Rect(0, 0, 4, 4), paletteOf(0xff)),
+		},
+		Delay: []int{10, 20},
+	}
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, a, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Frames) != 2 {
+		t.Fatalf("decoded %d frames, want 2", len(got.Frames))
+	}
+}
+
+// paletteOf returns a palette with the opaque gray v.
+func paletteOf(v uint8) color.Palette {
+	return color.Palette{color.Gray{v}}
+}
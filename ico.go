@@ -0,0 +1,210 @@
+package superimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"io"
+)
+
+// Icon is the set of images of an ICO or CUR file, usually one picture at several sizes.
+type Icon struct {
+	Images []image.Image
+
+	// Hotspots holds the point of every image of a cursor that marks the pointer
+	// position. Icons have none.
+	Hotspots []image.Point
+}
+
+// ICO and CUR files.
+const (
+	iconHeaderSize = 6
+	iconEntrySize  = 16
+	iconTypeICO    = 1
+	iconTypeCUR    = 2
+
+	// maxIconSize is the biggest side of an image in an icon. Images of this
+	// size are stored as png, the smaller ones as bitmaps.
+	maxIconSize = 256
+)
+
+// NewIcon returns an icon with img scaled to fit in squares of the given sizes,
+// which must be between 1 and 256.
+func NewIcon(img image.Image, sizes ...int) (*Icon, error) {
+	icon := &Icon{}
+	for _, size := range sizes {
+		if size <= 0 || size > maxIconSize {
+			return nil, ErrInvalidSize
+		}
+
+		w, h := fitSize(img.Bounds(), size, size)
+		scaled, err := Resize(img, w, h, CatmullRom)
+		if err != nil {
+			return nil, err
+		}
+		icon.Images = append(icon.Images, scaled.Image)
+	}
+
+	return icon, nil
+}
+
+// DecodeIcon decodes every image of an ICO or CUR file.
+func DecodeIcon(r io.Reader) (*Icon, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < iconHeaderSize || binary.LittleEndian.Uint16(data) != 0 {
+		return nil, formatError("ico", "bad header")
+	}
+	typ := binary.LittleEndian.Uint16(data[2:])
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if typ != iconTypeICO && typ != iconTypeCUR || count == 0 || iconHeaderSize+count*iconEntrySize > len(data) {
+		return nil, formatError("ico", "bad header")
+	}
+
+	icon := &Icon{Images: make([]image.Image, count)}
+	if typ == iconTypeCUR {
+		icon.Hotspots = make([]image.Point, count)
+	}
+
+	for i := range count {
+		entry := data[iconHeaderSize+i*iconEntrySize:]
+		size := uint64(binary.LittleEndian.Uint32(entry[8:]))
+		offset := uint64(binary.LittleEndian.Uint32(entry[12:]))
+		if offset+size > uint64(len(data)) {
+			return nil, formatError("ico", "bad image offset")
+		}
+		payload := data[offset : offset+size]
+
+		var img image.Image
+		if bytes.HasPrefix(payload, pngSignature) {
+			img, err = png.Decode(bytes.NewReader(payload))
+		} else {
+			img, err = decodeDIB(payload, -1, true)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		icon.Images[i] = img
+		if typ == iconTypeCUR {
+			icon.Hotspots[i] = image.Pt(int(binary.LittleEndian.Uint16(entry[4:])), int(binary.LittleEndian.Uint16(entry[6:])))
+		}
+	}
+
+	return icon, nil
+}
+
+// EncodeIcon writes an icon as an ICO file, or as a CUR file if it has hotspots.
+// Every image must fit in 256x256.
+func EncodeIcon(w io.Writer, icon *Icon) error {
+	if len(icon.Images) == 0 || len(icon.Images) > 0xffff {
+		return ErrInvalidSize
+	}
+
+	typ := uint16(iconTypeICO)
+	if icon.Hotspots != nil {
+		typ = iconTypeCUR
+	}
+
+	header := make([]byte, iconHeaderSize+len(icon.Images)*iconEntrySize)
+	binary.LittleEndian.PutUint16(header[2:], typ)
+	binary.LittleEndian.PutUint16(header[4:], uint16(len(icon.Images)))
+
+	var payloads bytes.Buffer
+	for i, img := range icon.Images {
+		img = unwrap(img)
+		bounds := img.Bounds()
+		if bounds.Empty() || bounds.Dx() > maxIconSize || bounds.Dy() > maxIconSize {
+			return ErrInvalidSize
+		}
+
+		offset := len(header) + payloads.Len()
+		if bounds.Dx() == maxIconSize || bounds.Dy() == maxIconSize {
+			if err := png.Encode(&payloads, img); err != nil {
+				return err
+			}
+		} else if err := writeIconBitmap(&payloads, img); err != nil {
+			return err
+		}
+
+		// A size of 256 is stored as 0.
+		entry := header[iconHeaderSize+i*iconEntrySize:]
+		entry[0], entry[1] = uint8(bounds.Dx()), uint8(bounds.Dy())
+		if typ == iconTypeCUR && i < len(icon.Hotspots) {
+			binary.LittleEndian.PutUint16(entry[4:], uint16(icon.Hotspots[i].X))
+			binary.LittleEndian.PutUint16(entry[6:], uint16(icon.Hotspots[i].Y))
+		} else if typ == iconTypeICO {
+			binary.LittleEndian.PutUint16(entry[4:], 1)
+			binary.LittleEndian.PutUint16(entry[6:], 32)
+		}
+		binary.LittleEndian.PutUint32(entry[8:], uint32(len(header)+payloads.Len()-offset))
+		binary.LittleEndian.PutUint32(entry[12:], uint32(offset))
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payloads.Bytes())
+	return err
+}
+
+// writeIconBitmap writes img as the 32-bit bitmap of an icon entry.
+func writeIconBitmap(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	header := make([]byte, bmpInfoHeaderSize)
+	binary.LittleEndian.PutUint32(header, bmpInfoHeaderSize)
+	binary.LittleEndian.PutUint32(header[4:], uint32(width))
+	// The height counts the pixels and the transparency mask.
+	binary.LittleEndian.PutUint32(header[8:], uint32(height*2))
+	binary.LittleEndian.PutUint16(header[12:], 1)
+	binary.LittleEndian.PutUint16(header[14:], 32)
+	binary.LittleEndian.PutUint32(header[20:], uint32((bmpStride(width, 32)+bmpStride(width, 1))*height))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	return writeBMPRows(w, img, 32, true)
+}
+
+// decodeICO decodes the biggest image of an ICO or CUR file.
+func decodeICO(r io.Reader) (image.Image, error) {
+	icon, err := DecodeIcon(r)
+	if err != nil {
+		return nil, err
+	}
+
+	best := icon.Images[0]
+	for _, img := range icon.Images[1:] {
+		b, i := best.Bounds(), img.Bounds()
+		if i.Dx()*i.Dy() > b.Dx()*b.Dy() {
+			best = img
+		}
+	}
+	return best, nil
+}
+
+// encodeICO writes m as a single image icon, scaled down to fit in 256x256 if needed.
+func encodeICO(w io.Writer, m image.Image, _ *EncodeOptions) error {
+	img, err := Fit(m, maxIconSize, maxIconSize, CatmullRom)
+	if err != nil {
+		return err
+	}
+
+	return EncodeIcon(w, &Icon{Images: []image.Image{img.Image}})
+}
+
+// encodeCUR writes m as a single image cursor pointing at its top left corner.
+func encodeCUR(w io.Writer, m image.Image, _ *EncodeOptions) error {
+	img, err := Fit(m, maxIconSize, maxIconSize, CatmullRom)
+	if err != nil {
+		return err
+	}
+
+	return EncodeIcon(w, &Icon{Images: []image.Image{img.Image}, Hotspots: []image.Point{{}}})
+}
@@ -0,0 +1,82 @@
+package superimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestIconRoundTrip(t *testing.T) {
+	img := scannerImages(image.Rect(0, 0, 300, 200), image.Point{})[0].img
+	icon, err := NewIcon(img, 16, 32, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	icon.Hotspots = []image.Point{{1, 2}, {3, 4}, {5, 6}}
+
+	var buf bytes.Buffer
+	if err := EncodeIcon(&buf, icon); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeIcon(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Images) != len(icon.Images) {
+		t.Fatalf("decoded %d images, want %d", len(got.Images), len(icon.Images))
+	}
+	for i, img := range icon.Images {
+		if got.Hotspots[i] != icon.Hotspots[i] {
+			t.Errorf("hotspot %d = %v, want %v", i, got.Hotspots[i], icon.Hotspots[i])
+		}
+		assertSameNRGBA(t, got.Images[i], img)
+	}
+	if size := icon.Images[2].Bounds().Size(); size != image.Pt(256, 171) {
+		t.Errorf("size of the biggest image = %v, want (256,171)", size)
+	}
+}
+
+func TestICOFormat(t *testing.T) {
+	img := scannerImages(image.Rect(0, 0, 40, 30), image.Point{})[0].img
+	for _, format := range []string{"ico", "cur"} {
+		t.Run(format, func(t *testing.T) {
+			got := roundTrip(t, img, &EncodeOptions{Format: format})
+			assertSameNRGBA(t, got, img)
+		})
+	}
+
+	if _, err := NewIcon(img, 257); err != ErrInvalidSize {
+		t.Fatalf("size 257: err = %v, want %v", err, ErrInvalidSize)
+	}
+}
+
+func TestICORejectsTruncated(t *testing.T) {
+	entry := func(size, offset uint32) []byte {
+		b := []byte{0, 0, 1, 0, 1, 0}
+		b = append(b, 0, 0, 0, 0, 1, 0, 32, 0)
+		b = binary.LittleEndian.AppendUint32(b, size)
+		return binary.LittleEndian.AppendUint32(b, offset)
+	}
+
+	// A DIB of 16000x32000 pixels, half of them the mask, and no pixels.
+	dib := binary.LittleEndian.AppendUint32(nil, bmpInfoHeaderSize)
+	dib = binary.LittleEndian.AppendUint32(dib, 16000)
+	dib = binary.LittleEndian.AppendUint32(dib, 32000)
+	dib = binary.LittleEndian.AppendUint16(dib, 1)
+	dib = binary.LittleEndian.AppendUint16(dib, 32)
+	dib = append(dib, make([]byte, 24)...)
+
+	tests := map[string][]byte{
+		"no images":     {0, 0, 1, 0, 0, 0},
+		"short entries": entry(0, 0)[:12],
+		"bad offset":    entry(100, 22),
+		"dib no pixels": append(entry(uint32(len(dib)), 22), dib...),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertRejected(t, "ico", data)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package superimage
+
+import "errors"
+
+// TIFF flavour of LZW: codes of 9 to 12 bits packed most significant bit first,
+// whose width grows one code earlier than in compress/lzw ("early change").
+const (
+	lzwClear    = 256
+	lzwEOI      = 257
+	lzwFirst    = 258
+	lzwMinWidth = 9
+	lzwMaxWidth = 12
+	lzwMaxCodes = 1 << lzwMaxWidth
+)
+
+var errLZW = errors.New("invalid LZW data")
+
+// tiffLZWDecode decompresses src, stopping after max bytes. The output grows with
+// the decoded data, so max does not need to be trusted.
+func tiffLZWDecode(src []byte, max int) ([]byte, error) {
+	var prefix [lzwMaxCodes]uint16
+	var suffix [lzwMaxCodes]byte
+	var first [lzwMaxCodes]byte
+	var length [lzwMaxCodes]int
+	for i := range 256 {
+		suffix[i], first[i], length[i] = byte(i), byte(i), 1
+	}
+
+	var out []byte
+	var acc uint32
+	var nbits, pos int
+	width, next, prev := lzwMinWidth, lzwFirst, -1
+
+	for len(out) < max {
+		for nbits < width {
+			if pos >= len(src) {
+				return out, nil
+			}
+			acc = acc<<8 | uint32(src[pos])
+			pos++
+			nbits += 8
+		}
+		code := int(acc>>(nbits-width)) & (1<<width - 1)
+		nbits -= width
+
+		switch {
+		case code == lzwEOI:
+			return out, nil
+		case code == lzwClear:
+			width, next, prev = lzwMinWidth, lzwFirst, -1
+			continue
+		case prev < 0:
+			if code > 0xff {
+				return nil, errLZW
+			}
+			out = append(out, byte(code))
+			prev = code
+			continue
+		case code > next || code == next && next >= lzwMaxCodes:
+			return nil, errLZW
+		}
+
+		// Add the previous string plus the first byte of this one.
+		if next < lzwMaxCodes {
+			head := first[prev]
+			if code < next {
+				head = first[code]
+			}
+			prefix[next] = uint16(prev)
+			suffix[next] = head
+			first[next] = first[prev]
+			length[next] = length[prev] + 1
+			next++
+		}
+
+		// Write the string of code backwards from its last byte.
+		n := length[code]
+		start := len(out)
+		out = append(out, make([]byte, n)...)
+		for c, i := code, start+n-1; i >= start; i-- {
+			out[i] = suffix[c]
+			c = int(prefix[c])
+		}
+		prev = code
+
+		if next >= 1<<width-1 && width < lzwMaxWidth {
+			width++
+		}
+	}
+
+	return out[:max], nil
+}
+
+// tiffLZWEncode compresses src.
+func tiffLZWEncode(src []byte) []byte {
+	var out []byte
+	var acc uint32
+	var nbits int
+	width := lzwMinWidth
+
+	write := func(code int) {
+		acc = acc<<width | uint32(code)
+		nbits += width
+		for nbits >= 8 {
+			out = append(out, byte(acc>>(nbits-8)))
+			nbits -= 8
+		}
+	}
+
+	dict := make(map[uint32]uint16)
+	next := lzwFirst
+	// grow accounts for a new code, clearing the table when it is full.
+	grow := func() {
+		next++
+		if next == lzwMaxCodes-2 {
+			write(lzwClear)
+			clear(dict)
+			width, next = lzwMinWidth, lzwFirst
+		} else if next > 1<<width-1 {
+			width++
+		}
+	}
+
+	write(lzwClear)
+	if len(src) > 0 {
+		code := int(src[0])
+		for _, b := range src[1:] {
+			key := uint32(code)<<8 | uint32(b)
+			if c, ok := dict[key]; ok {
+				code = int(c)
+				continue
+			}
+
+			write(code)
+			dict[key] = uint16(next)
+			grow()
+			code = int(b)
+		}
+		write(code)
+		grow()
+	}
+	write(lzwEOI)
+
+	if nbits > 0 {
+		out = append(out, byte(acc<<(8-nbits)))
+	}
+	return out
+}
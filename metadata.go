@@ -0,0 +1,481 @@
+package superimage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+)
+
+// Metadata holds the data of an image file that is not pixels.
+// Decode fills it for jpeg and png files and Encode writes it back for those formats.
+type Metadata struct {
+	// EXIF is the raw TIFF structure of the EXIF data, without the "Exif\x00\x00" header.
+	EXIF []byte
+
+	// ICCProfile is the raw ICC color profile.
+	ICCProfile []byte
+
+	// XMP is the raw XMP packet.
+	XMP []byte
+
+	// Text holds the PNG tEXt, zTXt and iTXt chunks, except the one with the XMP packet.
+	Text []TextChunk
+}
+
+// TextChunk is a PNG textual chunk.
+type TextChunk struct {
+	Keyword string
+	Text    string
+
+	// International chunks (iTXt) are UTF-8 and may have a language and a translated keyword.
+	// The others (tEXt) are Latin-1.
+	International     bool
+	Language          string
+	TranslatedKeyword string
+}
+
+// Clone returns a deep copy of md, or nil if md is nil.
+func (md *Metadata) Clone() *Metadata {
+	if md == nil {
+		return nil
+	}
+
+	return &Metadata{
+		EXIF:       slices.Clone(md.EXIF),
+		ICCProfile: slices.Clone(md.ICCProfile),
+		XMP:        slices.Clone(md.XMP),
+		Text:       slices.Clone(md.Text),
+	}
+}
+
+// empty reports whether md has nothing to write.
+func (md *Metadata) empty() bool {
+	return md == nil || len(md.EXIF) == 0 && len(md.ICCProfile) == 0 && len(md.XMP) == 0 && len(md.Text) == 0
+}
+
+const (
+	// maxMetadataChunk is the biggest metadata chunk kept while decoding a png file.
+	maxMetadataChunk = 16 << 20
+
+	// Keyword of the iTXt chunk that holds the XMP packet in png files.
+	pngXMPKeyword = "XML:com.adobe.xmp"
+
+	// jpegSegmentSize is the biggest payload of a jpeg marker segment.
+	jpegSegmentSize = 0xffff - 2
+)
+
+var (
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+	jpegXMPHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegICCHeader = []byte("ICC_PROFILE\x00")
+)
+
+// jpegMetadata reads the metadata segments at the start of a jpeg file.
+func jpegMetadata(data []byte) *Metadata {
+	md := &Metadata{}
+
+	type iccChunk struct {
+		seq  byte
+		data []byte
+	}
+	var icc []iccChunk
+
+	for _, s := range jpegSegments(data) {
+		switch {
+		case s.marker == 0xe1 && bytes.HasPrefix(s.payload, exifHeader) && md.EXIF == nil:
+			md.EXIF = slices.Clone(s.payload[len(exifHeader):])
+		case s.marker == 0xe1 && bytes.HasPrefix(s.payload, jpegXMPHeader) && md.XMP == nil:
+			md.XMP = slices.Clone(s.payload[len(jpegXMPHeader):])
+		case s.marker == 0xe2 && bytes.HasPrefix(s.payload, jpegICCHeader) && len(s.payload) >= len(jpegICCHeader)+2:
+			// The profile may be split in several segments numbered from 1.
+			p := s.payload[len(jpegICCHeader):]
+			icc = append(icc, iccChunk{seq: p[0], data: p[2:]})
+		}
+	}
+
+	slices.SortStableFunc(icc, func(a, b iccChunk) int {
+		return int(a.seq) - int(b.seq)
+	})
+	for _, c := range icc {
+		md.ICCProfile = append(md.ICCProfile, c.data...)
+	}
+
+	if md.empty() {
+		return nil
+	}
+	return md
+}
+
+// jpegICCChunkSize is the most bytes of an ICC profile stored in one APP2 segment.
+var jpegICCChunkSize = jpegSegmentSize - len(jpegICCHeader) - 2
+
+// checkJPEGMetadata returns an error wrapping ErrMetadataTooLarge if a block of md
+// does not fit in jpeg marker segments: EXIF and XMP take one segment each, and the
+// ICC profile at most 255.
+func checkJPEGMetadata(md *Metadata) error {
+	switch {
+	case len(exifHeader)+len(md.EXIF) > jpegSegmentSize:
+		return fmt.Errorf("%w: jpeg EXIF of %d bytes", ErrMetadataTooLarge, len(md.EXIF))
+	case len(jpegXMPHeader)+len(md.XMP) > jpegSegmentSize:
+		return fmt.Errorf("%w: jpeg XMP of %d bytes", ErrMetadataTooLarge, len(md.XMP))
+	case len(md.ICCProfile) > 0xff*jpegICCChunkSize:
+		return fmt.Errorf("%w: jpeg ICC profile of %d bytes", ErrMetadataTooLarge, len(md.ICCProfile))
+	}
+	return nil
+}
+
+// writeJPEGMetadata writes the marker segments of md.
+func writeJPEGMetadata(w io.Writer, md *Metadata) error {
+	if err := checkJPEGMetadata(md); err != nil {
+		return err
+	}
+
+	segment := func(marker byte, parts ...[]byte) error {
+		var n int
+		for _, p := range parts {
+			n += len(p)
+		}
+
+		header := []byte{0xff, marker, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], uint16(n+2))
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		for _, p := range parts {
+			if _, err := w.Write(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(md.EXIF) > 0 {
+		if err := segment(0xe1, exifHeader, md.EXIF); err != nil {
+			return err
+		}
+	}
+
+	if len(md.XMP) > 0 {
+		if err := segment(0xe1, jpegXMPHeader, md.XMP); err != nil {
+			return err
+		}
+	}
+
+	if len(md.ICCProfile) > 0 {
+		size := jpegICCChunkSize
+		count := (len(md.ICCProfile) + size - 1) / size
+		for i := range count {
+			chunk := md.ICCProfile[i*size : min((i+1)*size, len(md.ICCProfile))]
+			if err := segment(0xe2, jpegICCHeader, []byte{byte(i + 1), byte(count)}, chunk); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// jpegMetadataWriter inserts metadata segments right after the start of image marker.
+type jpegMetadataWriter struct {
+	w       io.Writer
+	md      *Metadata
+	written int
+}
+
+func (j *jpegMetadataWriter) Write(p []byte) (int, error) {
+	n := 0
+	if j.written < 2 {
+		soi := min(2-j.written, len(p))
+		if _, err := j.w.Write(p[:soi]); err != nil {
+			return 0, err
+		}
+		j.written += soi
+		n, p = soi, p[soi:]
+
+		if j.written == 2 {
+			if err := writeJPEGMetadata(j.w, j.md); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	m, err := j.w.Write(p)
+	return n + m, err
+}
+
+// pngMetadataReader collects the metadata chunks of a png stream written to it,
+// skipping the rest without keeping it.
+type pngMetadataReader struct {
+	md Metadata
+
+	header  [8]byte
+	filled  int
+	skipped int
+	// Remaining bytes of the current chunk data plus its CRC.
+	remaining int
+	chunkType string
+	data      []byte
+	keep      bool
+}
+
+func (r *pngMetadataReader) Write(p []byte) (int, error) {
+	n := len(p)
+
+	// Signature.
+	if r.skipped < len(pngSignature) {
+		s := min(len(pngSignature)-r.skipped, len(p))
+		r.skipped += s
+		p = p[s:]
+	}
+
+	for len(p) > 0 {
+		if r.remaining == 0 {
+			c := copy(r.header[r.filled:], p)
+			r.filled += c
+			p = p[c:]
+			if r.filled < len(r.header) {
+				break
+			}
+
+			length := int(binary.BigEndian.Uint32(r.header[:4]))
+			r.chunkType = string(r.header[4:8])
+			r.filled = 0
+			r.remaining = length + 4
+			r.keep = length <= maxMetadataChunk && slices.Contains([]string{"eXIf", "iCCP", "tEXt", "zTXt", "iTXt"}, r.chunkType)
+			r.data = r.data[:0]
+			continue
+		}
+
+		c := min(r.remaining, len(p))
+		if r.keep {
+			// The last 4 bytes are the CRC.
+			r.data = append(r.data, p[:min(c, max(0, r.remaining-4))]...)
+		}
+		r.remaining -= c
+		p = p[c:]
+
+		if r.remaining == 0 && r.keep {
+			r.chunk(r.chunkType, r.data)
+		}
+	}
+
+	return n, nil
+}
+
+// chunk stores a complete metadata chunk. Malformed chunks are ignored.
+func (r *pngMetadataReader) chunk(typ string, data []byte) {
+	switch typ {
+	case "eXIf":
+		r.md.EXIF = slices.Clone(data)
+
+	case "iCCP":
+		// Profile name, null separator, compression method and zlib data.
+		name, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(name) == 0 || len(rest) < 1 || rest[0] != 0 {
+			return
+		}
+		if profile, err := inflate(rest[1:]); err == nil {
+			r.md.ICCProfile = profile
+		}
+
+	case "tEXt":
+		keyword, text, ok := bytes.Cut(data, []byte{0})
+		if !ok {
+			return
+		}
+		r.md.Text = append(r.md.Text, TextChunk{Keyword: latin1(keyword), Text: latin1(text)})
+
+	case "zTXt":
+		keyword, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(rest) < 1 || rest[0] != 0 {
+			return
+		}
+		text, err := inflate(rest[1:])
+		if err != nil {
+			return
+		}
+		r.md.Text = append(r.md.Text, TextChunk{Keyword: latin1(keyword), Text: latin1(text)})
+
+	case "iTXt":
+		// Keyword, compression flag and method, language, translated keyword and text.
+		keyword, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(rest) < 2 {
+			return
+		}
+		compressed := rest[0] == 1
+		language, rest, ok := bytes.Cut(rest[2:], []byte{0})
+		if !ok {
+			return
+		}
+		translated, text, ok := bytes.Cut(rest, []byte{0})
+		if !ok {
+			return
+		}
+		if compressed {
+			var err error
+			if text, err = inflate(text); err != nil {
+				return
+			}
+		}
+
+		if string(keyword) == pngXMPKeyword {
+			r.md.XMP = slices.Clone(text)
+			return
+		}
+		r.md.Text = append(r.md.Text, TextChunk{
+			Keyword:           string(keyword),
+			Text:              string(text),
+			International:     true,
+			Language:          string(language),
+			TranslatedKeyword: string(translated),
+		})
+	}
+}
+
+// metadata returns the collected metadata, or nil if there was none.
+func (r *pngMetadataReader) metadata() *Metadata {
+	if r.md.empty() {
+		return nil
+	}
+	md := r.md
+	return &md
+}
+
+// writePNGMetadata writes the chunks of md.
+func writePNGMetadata(w io.Writer, md *Metadata) error {
+	if len(md.ICCProfile) > 0 {
+		var buf bytes.Buffer
+		buf.WriteString("ICC Profile\x00\x00")
+		zw := zlib.NewWriter(&buf)
+		zw.Write(md.ICCProfile)
+		zw.Close()
+		if err := writePNGChunk(w, "iCCP", buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if len(md.EXIF) > 0 {
+		if err := writePNGChunk(w, "eXIf", md.EXIF); err != nil {
+			return err
+		}
+	}
+
+	if len(md.XMP) > 0 {
+		data := append([]byte(pngXMPKeyword+"\x00\x00\x00\x00\x00"), md.XMP...)
+		if err := writePNGChunk(w, "iTXt", data); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range md.Text {
+		var data []byte
+		if t.International {
+			data = append(data, t.Keyword...)
+			data = append(data, 0, 0, 0)
+			data = append(data, t.Language...)
+			data = append(data, 0)
+			data = append(data, t.TranslatedKeyword...)
+			data = append(data, 0)
+			data = append(data, t.Text...)
+			if err := writePNGChunk(w, "iTXt", data); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data = append(data, toLatin1(t.Keyword)...)
+		data = append(data, 0)
+		data = append(data, toLatin1(t.Text)...)
+		if err := writePNGChunk(w, "tEXt", data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writePNGChunk writes a png chunk with its length and CRC.
+func writePNGChunk(w io.Writer, typ string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], typ)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	footer := binary.BigEndian.AppendUint32(nil, crc.Sum32())
+
+	for _, b := range [][]byte{header, data, footer} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pngMetadataWriter inserts metadata chunks right after the IHDR chunk,
+// where they are valid for every chunk type.
+type pngMetadataWriter struct {
+	w       io.Writer
+	md      *Metadata
+	written int
+}
+
+// pngHeaderSize is the size of the png signature and the IHDR chunk.
+const pngHeaderSize = 8 + 8 + 13 + 4
+
+func (pw *pngMetadataWriter) Write(p []byte) (int, error) {
+	n := 0
+	if pw.written < pngHeaderSize {
+		h := min(pngHeaderSize-pw.written, len(p))
+		if _, err := pw.w.Write(p[:h]); err != nil {
+			return 0, err
+		}
+		pw.written += h
+		n, p = h, p[h:]
+
+		if pw.written == pngHeaderSize {
+			if err := writePNGMetadata(pw.w, pw.md); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	m, err := pw.w.Write(p)
+	return n + m, err
+}
+
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return io.ReadAll(io.LimitReader(zr, maxMetadataChunk))
+}
+
+// latin1 converts Latin-1 bytes to a string.
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// toLatin1 converts a string to Latin-1 bytes, replacing the characters it cannot hold.
+func toLatin1(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	return b
+}
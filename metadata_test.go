@@ -0,0 +1,84 @@
+package superimage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"slices"
+	"testing"
+)
+
+func testMetadata() *Metadata {
+	return &Metadata{
+		EXIF:       []byte("MM\x00*\x00\x00\x00\x08\x00\x00"),
+		ICCProfile: bytes.Repeat([]byte("icc"), 30000),
+		XMP:        []byte("<x:xmpmeta/>"),
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	img := New(scannerImages(image.Rect(0, 0, 16, 16), image.Point{})[0].img, "png").WithMetadata(testMetadata())
+	img.metadata.Text = []TextChunk{{Keyword: "Title", Text: "test"}, {Keyword: "Author", Text: "ñ", International: true}}
+
+	for _, format := range []string{"jpeg", "png"} {
+		t.Run(format, func(t *testing.T) {
+			got := roundTrip(t, img, &EncodeOptions{Format: format}).Metadata()
+			if got == nil {
+				t.Fatal("no metadata decoded")
+			}
+			want := img.Metadata()
+			if !bytes.Equal(got.EXIF, want.EXIF) || !bytes.Equal(got.ICCProfile, want.ICCProfile) || !bytes.Equal(got.XMP, want.XMP) {
+				t.Fatalf("metadata = %+v, want %+v", got, want)
+			}
+			if format == "png" && !slices.Equal(got.Text, want.Text) {
+				t.Fatalf("text = %v, want %v", got.Text, want.Text)
+			}
+		})
+	}
+
+	t.Run("strip", func(t *testing.T) {
+		got := roundTrip(t, img, &EncodeOptions{Format: "jpeg", StripMetadata: true})
+		if md := got.Metadata(); md != nil && !md.empty() {
+			t.Fatalf("metadata = %+v, want none", md)
+		}
+	})
+}
+
+func TestJPEGMetadataTooLarge(t *testing.T) {
+	img := New(image.NewGray(image.Rect(0, 0, 8, 8)), "jpeg")
+	tests := map[string]*Metadata{
+		"EXIF": {EXIF: make([]byte, 1<<16)},
+		"XMP":  {XMP: make([]byte, 1<<16)},
+		"ICC":  {ICCProfile: make([]byte, 256*jpegICCChunkSize)},
+	}
+	for name, md := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := Encode(&buf, img.WithMetadata(md), &EncodeOptions{Format: "jpeg"})
+			if !errors.Is(err, ErrMetadataTooLarge) {
+				t.Fatalf("err = %v, want %v", err, ErrMetadataTooLarge)
+			}
+			if buf.Len() != 0 {
+				t.Fatalf("wrote %d bytes, want none", buf.Len())
+			}
+		})
+	}
+}
+
+func TestEffectsCopyMetadata(t *testing.T) {
+	src := New(image.NewNRGBA(image.Rect(0, 0, 8, 8)), "png").WithMetadata(testMetadata())
+	derived := []*SuperImage{Negative(src), Flip(src), src.As("jpeg")}
+
+	for _, d := range derived {
+		md := d.Metadata()
+		if md == src.Metadata() || !bytes.Equal(md.EXIF, src.Metadata().EXIF) {
+			t.Fatalf("metadata = %p %v, want a copy of %p", md, md.EXIF, src.Metadata())
+		}
+		md.EXIF[0] = 'I'
+		md.XMP = nil
+	}
+
+	if got := src.Metadata(); got.EXIF[0] != 'M' || got.XMP == nil {
+		t.Fatal("changing the metadata of a derived image changed the source")
+	}
+}
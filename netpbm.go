@@ -0,0 +1,507 @@
+package superimage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// NetpbmOptions are the options of the PBM, PGM and PPM encoders.
+// A nil *NetpbmOptions writes binary files.
+type NetpbmOptions struct {
+	// ASCII writes the samples as decimal numbers (P1, P2 and P3) instead of
+	// bytes (P4, P5 and P6). PAM files are always binary.
+	ASCII bool
+}
+
+// netpbmHeader describes the image of a Netpbm file.
+type netpbmHeader struct {
+	magic         string
+	width, height int
+	// Samples per pixel and their maximum value. PBM files have a maxval of 1
+	// where 1 is black.
+	depth, maxval int
+	// Tuple type of PAM files, like GRAYSCALE or RGB_ALPHA.
+	tupleType string
+}
+
+func (h *netpbmHeader) ascii() bool {
+	return h.magic == "P1" || h.magic == "P2" || h.magic == "P3"
+}
+
+// netpbmReader reads the whitespace separated tokens of Netpbm headers and ASCII data.
+type netpbmReader struct {
+	*bufio.Reader
+}
+
+// token returns the next word, skipping whitespace and comments.
+func (r netpbmReader) token() (string, error) {
+	var sb strings.Builder
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && sb.Len() > 0 {
+				return sb.String(), nil
+			}
+			return "", err
+		}
+
+		switch {
+		case b == '#' && sb.Len() == 0:
+			if _, err := r.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f':
+			if sb.Len() > 0 {
+				return sb.String(), nil
+			}
+		default:
+			sb.WriteByte(b)
+		}
+	}
+}
+
+// number returns the next token as a non-negative number.
+func (r netpbmReader) number() (int, error) {
+	tok, err := r.token()
+	if err != nil {
+		return 0, formatError("netpbm", "truncated header")
+	}
+
+	n, err := strconv.Atoi(tok)
+	if err != nil || n < 0 {
+		return 0, formatError("netpbm", "bad number "+strconv.Quote(tok))
+	}
+	return n, nil
+}
+
+// header reads the header of a Netpbm file, up to the single whitespace that
+// ends it and precedes the binary data.
+func (r netpbmReader) header() (*netpbmHeader, error) {
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+
+	h := &netpbmHeader{magic: string(magic), maxval: 1}
+	var err error
+	switch h.magic {
+	case "P1", "P4", "P2", "P5", "P3", "P6":
+		if h.width, err = r.number(); err != nil {
+			return nil, err
+		}
+		if h.height, err = r.number(); err != nil {
+			return nil, err
+		}
+
+		h.depth = 1
+		if h.magic == "P3" || h.magic == "P6" {
+			h.depth = 3
+		}
+		if h.magic != "P1" && h.magic != "P4" {
+			if h.maxval, err = r.number(); err != nil {
+				return nil, err
+			}
+		}
+
+	case "P7":
+		for {
+			key, err := r.token()
+			if err != nil {
+				return nil, formatError("pam", "truncated header")
+			}
+			if key == "ENDHDR" {
+				break
+			}
+
+			if key == "TUPLTYPE" {
+				if h.tupleType, err = r.token(); err != nil {
+					return nil, formatError("pam", "truncated header")
+				}
+				continue
+			}
+
+			n, err := r.number()
+			if err != nil {
+				return nil, err
+			}
+			switch key {
+			case "WIDTH":
+				h.width = n
+			case "HEIGHT":
+				h.height = n
+			case "DEPTH":
+				h.depth = n
+			case "MAXVAL":
+				h.maxval = n
+			}
+		}
+
+	default:
+		return nil, formatError("netpbm", "bad magic number")
+	}
+
+	if h.width <= 0 || h.height <= 0 || h.width > maxImagePixels/h.height {
+		return nil, formatError("netpbm", "bad image size")
+	}
+	if h.maxval < 1 || h.maxval > 0xffff || h.depth < 1 || h.depth > 4 {
+		return nil, formatError("netpbm", "bad maxval or depth")
+	}
+
+	return h, nil
+}
+
+// decodeNetpbm decodes PBM, PGM, PPM and PAM files, ASCII or binary. Images with
+// a maxval above 255 are decoded as *image.Gray16 or *image.NRGBA64, the others as
+// *image.Gray or *image.NRGBA. Samples are scaled to the full range of the type.
+func decodeNetpbm(r io.Reader) (image.Image, error) {
+	nr := netpbmReader{bufio.NewReader(r)}
+	h, err := nr.header()
+	if err != nil {
+		return nil, err
+	}
+
+	// samples returns the samples of the next row.
+	samples, err := h.rowReader(nr)
+	if err != nil {
+		return nil, err
+	}
+
+	rect := image.Rect(0, 0, h.width, h.height)
+	wide := h.maxval > 0xff
+	bilevel := h.magic == "P1" || h.magic == "P4"
+
+	scale := func(v int) int {
+		if wide {
+			return (v*0xffff + h.maxval/2) / h.maxval
+		}
+		return (v*0xff + h.maxval/2) / h.maxval
+	}
+
+	// The pixels are allocated row by row as they are read, so a header alone cannot
+	// force a huge allocation. pix is the buffer of img and stride its row size.
+	var img image.Image
+	var pix *[]uint8
+	var stride int
+	var set func(x, y int, s []int)
+	switch {
+	case h.depth == 1 && wide:
+		gray := &image.Gray16{Stride: h.width * 2, Rect: rect}
+		img, pix, stride = gray, &gray.Pix, gray.Stride
+		set = func(x, y int, s []int) {
+			binary.BigEndian.PutUint16(gray.Pix[y*gray.Stride+x*2:], uint16(scale(s[0])))
+		}
+
+	case h.depth == 1:
+		gray := &image.Gray{Stride: h.width, Rect: rect}
+		img, pix, stride = gray, &gray.Pix, gray.Stride
+		set = func(x, y int, s []int) {
+			v := uint8(scale(s[0]))
+			if bilevel {
+				v = ^v
+			}
+			gray.Pix[y*gray.Stride+x] = v
+		}
+
+	case wide:
+		nrgba := &image.NRGBA64{Stride: h.width * 8, Rect: rect}
+		img, pix, stride = nrgba, &nrgba.Pix, nrgba.Stride
+		set = func(x, y int, s []int) {
+			c := color.NRGBA64{A: 0xffff}
+			switch h.depth {
+			case 2:
+				c.R, c.A = uint16(scale(s[0])), uint16(scale(s[1]))
+				c.G, c.B = c.R, c.R
+			default:
+				c.R, c.G, c.B = uint16(scale(s[0])), uint16(scale(s[1])), uint16(scale(s[2]))
+				if h.depth == 4 {
+					c.A = uint16(scale(s[3]))
+				}
+			}
+			nrgba.SetNRGBA64(x, y, c)
+		}
+
+	default:
+		nrgba := &image.NRGBA{Stride: h.width * 4, Rect: rect}
+		img, pix, stride = nrgba, &nrgba.Pix, nrgba.Stride
+		set = func(x, y int, s []int) {
+			p := nrgba.Pix[y*nrgba.Stride+x*4 : y*nrgba.Stride+x*4+4]
+			p[3] = 0xff
+			switch h.depth {
+			case 2:
+				p[0], p[3] = uint8(scale(s[0])), uint8(scale(s[1]))
+				p[1], p[2] = p[0], p[0]
+			default:
+				p[0], p[1], p[2] = uint8(scale(s[0])), uint8(scale(s[1])), uint8(scale(s[2]))
+				if h.depth == 4 {
+					p[3] = uint8(scale(s[3]))
+				}
+			}
+		}
+	}
+
+	for y := range h.height {
+		row, err := samples()
+		if err != nil {
+			return nil, err
+		}
+		*pix = slices.Grow(*pix, stride)[:len(*pix)+stride]
+		for x := range h.width {
+			set(x, y, row[x*h.depth:])
+		}
+	}
+
+	return img, nil
+}
+
+// netpbmChunk is the most bytes of a binary row read at once. The row buffers grow
+// with the samples actually read, so a header alone cannot force a huge allocation.
+const netpbmChunk = 1 << 16
+
+// rowReader returns a function that reads the samples of the rows of the image in order.
+func (h *netpbmHeader) rowReader(r netpbmReader) (func() ([]int, error), error) {
+	n := h.width * h.depth
+	var row []int
+	truncated := formatError("netpbm", "truncated data")
+
+	switch {
+	case h.magic == "P1":
+		// Bits may be written without whitespace between them.
+		return func() ([]int, error) {
+			row = row[:0]
+			for range n {
+				var b byte
+				var err error
+				for b != '0' && b != '1' {
+					if b, err = r.ReadByte(); err != nil {
+						return nil, truncated
+					}
+					if b == '#' {
+						r.ReadString('\n')
+					}
+				}
+				row = append(row, int(b-'0'))
+			}
+			return row, nil
+		}, nil
+
+	case h.ascii():
+		return func() ([]int, error) {
+			row = row[:0]
+			for range n {
+				v, err := r.number()
+				if err != nil {
+					return nil, truncated
+				}
+				row = append(row, min(v, h.maxval))
+			}
+			return row, nil
+		}, nil
+	}
+
+	// Samples of a chunk of a binary row.
+	rowBytes := n
+	decode := func(chunk []byte) {
+		for _, b := range chunk {
+			row = append(row, min(int(b), h.maxval))
+		}
+	}
+	switch {
+	case h.magic == "P4":
+		rowBytes = (h.width + 7) / 8
+		decode = func(chunk []byte) {
+			for _, b := range chunk {
+				for i := 7; i >= 0 && len(row) < n; i-- {
+					row = append(row, int(b>>i&1))
+				}
+			}
+		}
+
+	case h.maxval > 0xff:
+		rowBytes = n * 2
+		decode = func(chunk []byte) {
+			for i := 0; i < len(chunk); i += 2 {
+				row = append(row, min(int(binary.BigEndian.Uint16(chunk[i:])), h.maxval))
+			}
+		}
+	}
+
+	buf := make([]byte, min(rowBytes, netpbmChunk))
+	return func() ([]int, error) {
+		row = row[:0]
+		for left := rowBytes; left > 0; {
+			chunk := buf[:min(left, len(buf))]
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, truncated
+			}
+			decode(chunk)
+			left -= len(chunk)
+		}
+		return row, nil
+	}, nil
+}
+
+// netpbmWriter writes the samples of a Netpbm file, as bytes or as text lines
+// of at most 70 characters.
+type netpbmWriter struct {
+	*bufio.Writer
+	ascii bool
+	wide  bool
+	line  int
+}
+
+func (w *netpbmWriter) sample(v int) {
+	if !w.ascii {
+		if w.wide {
+			w.WriteByte(byte(v >> 8))
+		}
+		w.WriteByte(byte(v))
+		return
+	}
+
+	s := strconv.Itoa(v)
+	if w.line > 0 && w.line+1+len(s) > 70 {
+		w.WriteByte('\n')
+		w.line = 0
+	}
+	if w.line > 0 {
+		w.WriteByte(' ')
+		w.line++
+	}
+	w.WriteString(s)
+	w.line += len(s)
+}
+
+func (w *netpbmWriter) endRow() {
+	if w.ascii && w.line > 0 {
+		w.WriteByte('\n')
+		w.line = 0
+	}
+}
+
+// netpbmEncoder returns the encoder of one of the Netpbm formats: "pbm", "pgm", "ppm" or "pam".
+func netpbmEncoder(format string) EncodeFunc {
+	return func(w io.Writer, m image.Image, opts *EncodeOptions) error {
+		return encodeNetpbm(w, m, format, opts.NetpbmOpts != nil && opts.NetpbmOpts.ASCII)
+	}
+}
+
+// encodeNetpbm writes m as a PBM, PGM, PPM or PAM file. 16-bit images are written
+// with a maxval of 65535, the others with 255. PBM files get the pixels darker than
+// mid gray as black. PPM files drop the alpha channel, which PAM files keep.
+func encodeNetpbm(w io.Writer, m image.Image, format string, ascii bool) error {
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if format == "pam" {
+		ascii = false
+	}
+
+	wide := false
+	switch m.(type) {
+	case *image.Gray16, *image.NRGBA64, *image.RGBA64:
+		wide = format != "pbm"
+	}
+	maxval := 0xff
+	if wide {
+		maxval = 0xffff
+	}
+
+	// Gray images keep a single sample, the alpha channel is only written by PAM when needed.
+	gray := format == "pbm" || format == "pgm"
+	if format == "pam" {
+		switch m.(type) {
+		case *image.Gray, *image.Gray16:
+			gray = true
+		}
+	}
+	alpha := format == "pam" && !gray && !isOpaque(m)
+
+	depth := 3
+	switch {
+	case gray:
+		depth = 1
+	case alpha:
+		depth = 4
+	}
+
+	bw := bufio.NewWriter(w)
+	magic := map[string][2]string{
+		"pbm": {"P4", "P1"},
+		"pgm": {"P5", "P2"},
+		"ppm": {"P6", "P3"},
+	}[format]
+	switch {
+	case format == "pam":
+		tupleType := map[int]string{1: "GRAYSCALE", 3: "RGB", 4: "RGB_ALPHA"}[depth]
+		fmt.Fprintf(bw, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH %d\nMAXVAL %d\nTUPLTYPE %s\nENDHDR\n", width, height, depth, maxval, tupleType)
+	case ascii && format == "pbm":
+		fmt.Fprintf(bw, "%s\n%d %d\n", magic[1], width, height)
+	case ascii:
+		fmt.Fprintf(bw, "%s\n%d %d\n%d\n", magic[1], width, height, maxval)
+	case format == "pbm":
+		fmt.Fprintf(bw, "%s\n%d %d\n", magic[0], width, height)
+	default:
+		fmt.Fprintf(bw, "%s\n%d %d\n%d\n", magic[0], width, height, maxval)
+	}
+
+	out := &netpbmWriter{Writer: bw, ascii: ascii, wide: wide}
+	s := newScanner(m)
+	pixels := make([]uint8, width*4)
+	packed := make([]byte, (width+7)/8)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		if !wide {
+			s.scan(bounds.Min.X, y, bounds.Max.X, pixels)
+		}
+
+		clear(packed)
+		for x := range width {
+			var c [4]int
+			if wide {
+				n := color.NRGBA64Model.Convert(m.At(bounds.Min.X+x, y)).(color.NRGBA64)
+				c = [4]int{int(n.R), int(n.G), int(n.B), int(n.A)}
+			} else {
+				p := pixels[x*4:]
+				c = [4]int{int(p[0]), int(p[1]), int(p[2]), int(p[3])}
+			}
+
+			switch {
+			case format == "pbm":
+				// 1 is black.
+				black := 0
+				if luma(c) < maxval/2+1 {
+					black = 1
+				}
+				if ascii {
+					out.sample(black)
+				} else {
+					packed[x/8] |= byte(black << (7 - x%8))
+				}
+			case gray:
+				out.sample(luma(c))
+			default:
+				for _, v := range c[:depth] {
+					out.sample(v)
+				}
+			}
+		}
+
+		if format == "pbm" && !ascii {
+			bw.Write(packed)
+		}
+		out.endRow()
+	}
+
+	return bw.Flush()
+}
+
+// luma returns the Rec. 601 luma of a color, like color.GrayModel.
+func luma(c [4]int) int {
+	return (19595*c[0] + 38470*c[1] + 7471*c[2] + 1<<15) >> 16
+}
@@ -0,0 +1,73 @@
+package superimage
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestNetpbmRoundTrip(t *testing.T) {
+	r := image.Rect(0, 0, 37, 11)
+	bilevel := image.NewGray(r)
+	gray := image.NewGray(r)
+	gray16 := image.NewGray16(r)
+	rgb := image.NewNRGBA(r)
+	rgba := image.NewNRGBA(r)
+	for y := range r.Dy() {
+		for x := range r.Dx() {
+			if (x+y)%3 == 0 {
+				bilevel.SetGray(x, y, color.Gray{0xff})
+			}
+			gray.SetGray(x, y, color.Gray{uint8(x*7 + y)})
+			gray16.SetGray16(x, y, color.Gray16{uint16(x*1733 + y*31)})
+			rgb.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 23), uint8(x + y), 0xff})
+			rgba.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 23), uint8(x + y), uint8(x * y)})
+		}
+	}
+
+	tests := []struct {
+		name, format string
+		img          image.Image
+	}{
+		{"pbm", "pbm", bilevel},
+		{"pgm", "pgm", gray},
+		{"pgm16", "pgm", gray16},
+		{"ppm", "ppm", rgb},
+		{"pam", "pam", rgba},
+		{"pam16", "pam", gray16},
+	}
+	for _, tt := range tests {
+		for _, ascii := range []bool{false, true} {
+			name := tt.name
+			if ascii {
+				name += "/ascii"
+			}
+			t.Run(name, func(t *testing.T) {
+				got := roundTrip(t, tt.img, &EncodeOptions{Format: tt.format, NetpbmOpts: &NetpbmOptions{ASCII: ascii}})
+				if _, ok := tt.img.(*image.Gray16); ok {
+					if _, ok := got.Image.(*image.Gray16); !ok {
+						t.Fatalf("decoded a %T, want *image.Gray16", got.Image)
+					}
+				}
+				assertSameNRGBA(t, got, tt.img)
+			})
+		}
+	}
+}
+
+func TestNetpbmRejectsTruncated(t *testing.T) {
+	tests := map[string]string{
+		"P1 header only":   "P1 100000 1000\n",
+		"P4 header only":   "P4 100000 1000\n",
+		"P5 header only":   "P5 100000 1000 255\n",
+		"P6 16-bit":        "P6 16000 16000 65535\n",
+		"P7 one wide row":  "P7\nWIDTH 67108864\nHEIGHT 1\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n",
+		"P3 missing value": "P3 2 1 255\n1 2 3 4 5\n",
+		"too big":          "P5 1000000 1000000 255\n",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertRejected(t, "pnm", []byte(data))
+		})
+	}
+}
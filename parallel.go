@@ -0,0 +1,125 @@
+package superimage
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Options controls how the effects are executed.
+// A nil *Options uses every CPU and cannot be cancelled.
+type Options struct {
+	// Workers is the maximum number of goroutines used by an effect.
+	// Zero or negative means runtime.NumCPU().
+	Workers int
+
+	// Context cancels the effect when it is done. Nil means context.Background().
+	Context context.Context
+
+	// Progress, if not nil, is called with the fraction of work done, from 0 to 1.
+	// Calls are never concurrent.
+	Progress func(done float64)
+}
+
+func (o *Options) workers() int {
+	if o == nil || o.Workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return o.Workers
+}
+
+func (o *Options) context() context.Context {
+	if o == nil || o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+func (o *Options) progress() func(float64) {
+	if o == nil {
+		return nil
+	}
+	return o.Progress
+}
+
+// stage returns options for the i-th of n consecutive steps of an effect,
+// scaling the progress so the whole effect still goes from 0 to 1.
+func (o *Options) stage(i, n int) *Options {
+	if o == nil || o.Progress == nil || n <= 1 {
+		return o
+	}
+
+	stage := *o
+	stage.Progress = func(done float64) {
+		o.Progress((float64(i) + done) / float64(n))
+	}
+	return &stage
+}
+
+// chunksPerWorker splits the work in smaller pieces than one per worker,
+// so cancellation and progress are reported more often and slow rows are balanced.
+const chunksPerWorker = 8
+
+// parallelFor calls fn with consecutive ranges [lo, hi) covering [0, n),
+// from up to opts.workers() goroutines. It stops handing out ranges
+// when the context is cancelled and returns its error.
+func parallelFor(opts *Options, n int, fn func(lo, hi int)) error {
+	ctx := opts.context()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	workers := min(opts.workers(), n)
+	chunk := max(1, n/(workers*chunksPerWorker))
+	chunks := (n + chunk - 1) / chunk
+	workers = min(workers, chunks)
+
+	progress := opts.progress()
+	var mu sync.Mutex
+	var done int
+
+	var next atomic.Int64
+	var cancelled atomic.Bool
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				if ctx.Err() != nil {
+					cancelled.Store(true)
+					return
+				}
+
+				i := int(next.Add(1) - 1)
+				if i >= chunks {
+					return
+				}
+
+				lo := i * chunk
+				hi := min(lo+chunk, n)
+				fn(lo, hi)
+
+				if progress != nil {
+					mu.Lock()
+					done += hi - lo
+					progress(float64(done) / float64(n))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cancelled.Load() {
+		return ctx.Err()
+	}
+
+	return nil
+}
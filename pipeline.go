@@ -0,0 +1,309 @@
+package superimage
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// Effect is an image transformation that can be chained in a Pipeline.
+//
+// Apply writes the result of the effect applied to src. dst is a scratch buffer
+// that the effect may reuse when its bounds fit the result; it can be nil.
+// The returned image must be dst or a new image that shares no pixels with src.
+// opts may be nil and should be honoured by long running effects.
+type Effect interface {
+	Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error)
+}
+
+// EffectFunc adapts an ordinary function to the Effect interface.
+// The function never receives a scratch buffer.
+type EffectFunc func(src image.Image) (image.Image, error)
+
+func (f EffectFunc) Apply(_ *image.NRGBA, src image.Image, _ *Options) (image.Image, error) {
+	return f(src)
+}
+
+// NegativeEffect inverts the colors of an image. See Negative.
+type NegativeEffect struct{}
+
+func (NegativeEffect) Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error) {
+	return negative(dst, src, opts)
+}
+
+// FlipEffect turns an image upside down. See Flip.
+type FlipEffect struct{}
+
+func (FlipEffect) Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error) {
+	return flip(dst, src, opts)
+}
+
+// ReflectEffect mirrors an image. See Reflect.
+type ReflectEffect struct{}
+
+func (ReflectEffect) Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error) {
+	return reflect(dst, src, opts)
+}
+
+// BlurEffect blurs an image by Radius. See Blur.
+type BlurEffect struct {
+	Radius int
+}
+
+func (e BlurEffect) Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error) {
+	if e.Radius < 0 {
+		return nil, ErrNegativeRadio
+	}
+
+	return gaussianBlur(dst, src, blurSigma(e.Radius), opts)
+}
+
+// BoxBlurEffect averages the square of side 2*Radius+1 around every pixel. See BoxBlur.
+type BoxBlurEffect struct {
+	Radius int
+}
+
+func (e BoxBlurEffect) Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error) {
+	if e.Radius < 0 {
+		return nil, ErrNegativeRadio
+	}
+
+	return boxBlur(dst, src, e.Radius, opts)
+}
+
+// GaussianBlurEffect blurs an image with a Gaussian kernel of deviation Sigma. See GaussianBlur.
+type GaussianBlurEffect struct {
+	Sigma float64
+}
+
+func (e GaussianBlurEffect) Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error) {
+	if !validSigma(e.Sigma) {
+		return nil, ErrNegativeSigma
+	}
+
+	return gaussianBlur(dst, src, e.Sigma, opts)
+}
+
+// OpacityEffect multiplies the alpha channel of an image by Opacity. See Opacity.
+type OpacityEffect struct {
+	Opacity float64
+}
+
+func (e OpacityEffect) Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error) {
+	if e.Opacity > 1 || e.Opacity < 0 {
+		return nil, ErrInvalidOpacity
+	}
+
+	return opacity(dst, src, e.Opacity, opts)
+}
+
+// PixelateEffect pixelates an image with blocks of side Radius. See Pixelate.
+type PixelateEffect struct {
+	Radius int
+}
+
+func (e PixelateEffect) Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error) {
+	if e.Radius < 1 {
+		return nil, ErrNegativeRadio
+	}
+
+	return pixelate(dst, src, e.Radius, opts)
+}
+
+// GrayscaleEffect turns an image into shades of gray with Method. See Grayscale.
+type GrayscaleEffect struct {
+	Method GrayscaleMethod
+}
+
+func (e GrayscaleEffect) Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error) {
+	return grayscale(dst, src, e.Method, opts)
+}
+
+// SepiaEffect tones an image like an old photograph by Intensity. See Sepia.
+type SepiaEffect struct {
+	Intensity float64
+}
+
+func (e SepiaEffect) Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error) {
+	if e.Intensity > 1 || e.Intensity < 0 {
+		return nil, ErrInvalidIntensity
+	}
+
+	return mixChannels(dst, src, sepiaIntensity(e.Intensity), opts)
+}
+
+// ChannelMixerEffect mixes the channels of an image with Matrix. See ChannelMixer.
+type ChannelMixerEffect struct {
+	Matrix ChannelMatrix
+}
+
+func (e ChannelMixerEffect) Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error) {
+	return mixChannels(dst, src, e.Matrix, opts)
+}
+
+// ResizeEffect scales an image to Width x Height with Filter. See Resize.
+type ResizeEffect struct {
+	Width, Height int
+	Filter        ResampleFilter
+}
+
+func (e ResizeEffect) Apply(dst *image.NRGBA, src image.Image, opts *Options) (image.Image, error) {
+	return resize(dst, src, e.Width, e.Height, e.Filter, opts)
+}
+
+// Pipeline chains effects that are applied in order by Run.
+//
+//	out, err := superimage.NewPipeline().Blur(2).Opacity(0.5).Run(img)
+//
+// Invalid parameters are collected while building and reported by Run.
+type Pipeline struct {
+	effects []Effect
+	errs    []error
+	opts    *Options
+}
+
+// NewPipeline returns an empty Pipeline.
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// Add appends effects to the pipeline.
+func (p *Pipeline) Add(effects ...Effect) *Pipeline {
+	p.effects = append(p.effects, effects...)
+	return p
+}
+
+// WithOptions sets the Options passed to every effect, which allows
+// to limit the workers, cancel a run or follow its progress.
+func (p *Pipeline) WithOptions(opts *Options) *Pipeline {
+	p.opts = opts
+	return p
+}
+
+// Negative appends a NegativeEffect.
+func (p *Pipeline) Negative() *Pipeline {
+	return p.Add(NegativeEffect{})
+}
+
+// Flip appends a FlipEffect.
+func (p *Pipeline) Flip() *Pipeline {
+	return p.Add(FlipEffect{})
+}
+
+// Reflect appends a ReflectEffect.
+func (p *Pipeline) Reflect() *Pipeline {
+	return p.Add(ReflectEffect{})
+}
+
+// Blur appends a BlurEffect.
+func (p *Pipeline) Blur(radius int) *Pipeline {
+	if radius < 0 {
+		p.addErr("blur", ErrNegativeRadio)
+	}
+	return p.Add(BlurEffect{Radius: radius})
+}
+
+// BoxBlur appends a BoxBlurEffect.
+func (p *Pipeline) BoxBlur(radius int) *Pipeline {
+	if radius < 0 {
+		p.addErr("box blur", ErrNegativeRadio)
+	}
+	return p.Add(BoxBlurEffect{Radius: radius})
+}
+
+// GaussianBlur appends a GaussianBlurEffect.
+func (p *Pipeline) GaussianBlur(sigma float64) *Pipeline {
+	if !validSigma(sigma) {
+		p.addErr("gaussian blur", ErrNegativeSigma)
+	}
+	return p.Add(GaussianBlurEffect{Sigma: sigma})
+}
+
+// Opacity appends an OpacityEffect.
+func (p *Pipeline) Opacity(op float64) *Pipeline {
+	if op > 1 || op < 0 {
+		p.addErr("opacity", ErrInvalidOpacity)
+	}
+	return p.Add(OpacityEffect{Opacity: op})
+}
+
+// Pixelate appends a PixelateEffect.
+func (p *Pipeline) Pixelate(radius int) *Pipeline {
+	if radius < 1 {
+		p.addErr("pixelate", ErrNegativeRadio)
+	}
+	return p.Add(PixelateEffect{Radius: radius})
+}
+
+// Grayscale appends a GrayscaleEffect.
+func (p *Pipeline) Grayscale(method GrayscaleMethod) *Pipeline {
+	if method < GrayRec601 || method > GrayLightness {
+		p.addErr("grayscale", ErrInvalidMethod)
+	}
+	return p.Add(GrayscaleEffect{Method: method})
+}
+
+// Sepia appends a SepiaEffect.
+func (p *Pipeline) Sepia(intensity float64) *Pipeline {
+	if intensity > 1 || intensity < 0 {
+		p.addErr("sepia", ErrInvalidIntensity)
+	}
+	return p.Add(SepiaEffect{Intensity: intensity})
+}
+
+// ChannelMixer appends a ChannelMixerEffect.
+func (p *Pipeline) ChannelMixer(m ChannelMatrix) *Pipeline {
+	return p.Add(ChannelMixerEffect{Matrix: m})
+}
+
+// Resize appends a ResizeEffect.
+func (p *Pipeline) Resize(width, height int, filter ResampleFilter) *Pipeline {
+	if width < 0 || height < 0 || width == 0 && height == 0 {
+		p.addErr("resize", ErrInvalidSize)
+	}
+	return p.Add(ResizeEffect{Width: width, Height: height, Filter: filter})
+}
+
+func (p *Pipeline) addErr(name string, err error) {
+	p.errs = append(p.errs, fmt.Errorf("stage %d (%s): %w", len(p.effects), name, err))
+}
+
+// Err returns the errors collected while building the pipeline, if any.
+func (p *Pipeline) Err() error {
+	return errors.Join(p.errs...)
+}
+
+// Run applies every effect to img in order and returns the result with the format of img.
+// Buffers produced by a stage are reused as scratch space by the stage after the next one.
+func (p *Pipeline) Run(img image.Image) (*SuperImage, error) {
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+
+	src := unwrap(img)
+	cur := src
+
+	// owned holds the buffers allocated by the stages, which can be written again
+	// once no stage reads from them.
+	owned := make(map[*image.NRGBA]bool)
+	var spare *image.NRGBA
+
+	for i, effect := range p.effects {
+		out, err := effect.Apply(spare, cur, p.opts)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %w", i, err)
+		}
+
+		spare = nil
+		if buf, ok := cur.(*image.NRGBA); ok && owned[buf] && out != cur {
+			spare = buf
+		}
+		if buf, ok := out.(*image.NRGBA); ok && out != src {
+			owned[buf] = true
+		}
+
+		cur = out
+	}
+
+	return newLike(cur, img), nil
+}
@@ -0,0 +1,404 @@
+package superimage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"slices"
+)
+
+// PNGOptions enables the optimising PNG encoder. The pixels are also tried in the
+// smallest lossless color type, with several compression levels and row filters.
+// Every level and filter compresses the whole image once more.
+type PNGOptions struct {
+	// Levels are the compression levels tried, keeping the smallest file.
+	// Empty means png.DefaultCompression and png.BestCompression.
+	Levels []png.CompressionLevel
+
+	// Filters are the row filters tried, keeping the smallest file. Empty means
+	// PNGFilterAdaptive, plus PNGFilterNone for paletted images. Unknown filters
+	// make Encode fail with ErrInvalidMethod.
+	Filters []PNGFilter
+}
+
+// PNGFilter is the way the rows of a png are filtered before compressing them.
+type PNGFilter int
+
+const (
+	// PNGFilterAdaptive filters every row with the filter type that has the smallest
+	// sum of absolute differences, like image/png does.
+	PNGFilterAdaptive PNGFilter = iota
+
+	// PNGFilterNone, PNGFilterSub, PNGFilterUp, PNGFilterAverage and PNGFilterPaeth
+	// filter every row with that png filter type.
+	PNGFilterNone
+	PNGFilterSub
+	PNGFilterUp
+	PNGFilterAverage
+	PNGFilterPaeth
+)
+
+var defaultPNGLevels = []png.CompressionLevel{png.DefaultCompression, png.BestCompression}
+
+// maxPackedColors is the most colors written with less than 8 bits per pixel.
+const maxPackedColors = 16
+
+// encodeOptimizedPNG writes the smallest png of m, reduced or not, among the
+// compression levels and filters of opts.PngOpts. The palette of a small image
+// can take more room than its pixels. The BufferPool of opts.PngEnc is used if set.
+func encodeOptimizedPNG(w io.Writer, m image.Image, opts *EncodeOptions) error {
+	for _, filter := range opts.PngOpts.Filters {
+		if filter < PNGFilterAdaptive || filter > PNGFilterPaeth {
+			return ErrInvalidMethod
+		}
+	}
+
+	candidates := []image.Image{m}
+	if reduced := reducePNG(m); reduced != nil {
+		candidates = append(candidates, reduced)
+	}
+
+	levels := opts.PngOpts.Levels
+	if len(levels) == 0 {
+		levels = defaultPNGLevels
+	}
+
+	var best []byte
+	for _, img := range candidates {
+		// image/png writes the chunks of the color type, and the rows unfiltered
+		// when it does not compress them.
+		enc := &png.Encoder{CompressionLevel: png.NoCompression}
+		if opts.PngEnc != nil {
+			enc.BufferPool = opts.PngEnc.BufferPool
+		}
+
+		var buf bytes.Buffer
+		if err := enc.Encode(&buf, img); err != nil {
+			return err
+		}
+		raw, err := newRawPNG(buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		// Paletted images usually compress better without filters.
+		filters := opts.PngOpts.Filters
+		if len(filters) == 0 {
+			filters = []PNGFilter{PNGFilterAdaptive}
+			if _, ok := img.(*image.Paletted); ok {
+				filters = append(filters, PNGFilterNone)
+			}
+		}
+
+		for _, level := range levels {
+			for _, filter := range filters {
+				data, err := raw.encode(filter, pngZlibLevel(level))
+				if err != nil {
+					return err
+				}
+				if best == nil || len(data) < len(best) {
+					best = data
+				}
+			}
+		}
+	}
+
+	_, err := w.Write(best)
+	return err
+}
+
+// rawPNG is a png file with the rows of its image data decompressed and unfiltered.
+type rawPNG struct {
+	// before and after are the chunks around the image data.
+	before, after []pngChunk
+	rows          [][]uint8
+	bpp           int
+}
+
+// newRawPNG splits a png written without compression, so its rows are not filtered.
+func newRawPNG(file []byte) (*rawPNG, error) {
+	chunks, err := pngChunks(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].typ != "IHDR" || len(chunks[0].data) != 13 {
+		return nil, formatError("png", "missing IHDR chunk")
+	}
+
+	header := chunks[0].data
+	width := int(binary.BigEndian.Uint32(header))
+	height := int(binary.BigEndian.Uint32(header[4:]))
+	depth := int(header[8])
+	channels := map[uint8]int{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}[header[9]]
+	bits := channels * depth
+
+	p := &rawPNG{bpp: max(1, bits/8)}
+	var idat bytes.Buffer
+	for i, c := range chunks {
+		switch {
+		case c.typ == "IDAT":
+			idat.Write(c.data)
+		case idat.Len() == 0:
+			p.before = chunks[:i+1]
+		default:
+			p.after = append(p.after, c)
+		}
+	}
+
+	zr, err := zlib.NewReader(&idat)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	stride := 1 + (width*bits+7)/8
+	if len(data) != stride*height {
+		return nil, formatError("png", "bad image data size")
+	}
+	for y := range height {
+		row := data[y*stride : (y+1)*stride]
+		if row[0] != 0 {
+			return nil, formatError("png", "unexpected filtered row")
+		}
+		p.rows = append(p.rows, row[1:])
+	}
+
+	return p, nil
+}
+
+// encode writes the png with every row filtered with filter and compressed with the
+// zlib level.
+func (p *rawPNG) encode(filter PNGFilter, level int) ([]byte, error) {
+	var idat bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&idat, level)
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered [5][]uint8
+	prev := make([]uint8, len(p.rows[0]))
+	for i := range filtered {
+		filtered[i] = make([]uint8, 1+len(prev))
+	}
+
+	for _, row := range p.rows {
+		line := filtered[0]
+		if filter == PNGFilterAdaptive {
+			line = pngFilter(row, prev, p.bpp, &filtered)
+		} else {
+			pngFilterLine(int(filter-PNGFilterNone), row, prev, p.bpp, line)
+		}
+		if _, err := zw.Write(line); err != nil {
+			return nil, err
+		}
+		prev = row
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	for _, c := range p.before {
+		writePNGChunk(&buf, c.typ, c.data)
+	}
+	writePNGChunk(&buf, "IDAT", idat.Bytes())
+	for _, c := range p.after {
+		writePNGChunk(&buf, c.typ, c.data)
+	}
+	return buf.Bytes(), nil
+}
+
+// reducePNG returns img in the smallest color type that holds its pixels without loss:
+// *image.Paletted for 256 colors or fewer, *image.Gray or *image.Gray16 for opaque
+// gray images, and 8-bit types for 16-bit images whose samples fit in 8 bits.
+// The color of fully transparent pixels is not kept. It returns nil if img already
+// has the smallest type.
+func reducePNG(img image.Image) image.Image {
+	// src is the input, img the input narrowed to 8 bits if possible.
+	src := unwrap(img)
+	img = src
+	switch img.(type) {
+	case *image.Gray16, *image.NRGBA64, *image.RGBA64:
+		reduced, narrow := reducePNG16(img)
+		if !narrow {
+			return reduced
+		}
+		img = reduced
+	}
+
+	nrgba, err := toNRGBA(img, nil)
+	if err != nil {
+		return img
+	}
+	bounds := nrgba.Bounds()
+
+	// Colors in the order they are found, up to one more than a palette can hold.
+	var colors []color.NRGBA
+	seen := make(map[color.NRGBA]struct{})
+	gray, opaque := true, true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := nrgba.PixOffset(bounds.Min.X, y)
+		for p := nrgba.Pix[i : i+bounds.Dx()*4]; len(p) > 0; p = p[4:] {
+			c := transparentNRGBA(p)
+			gray = gray && c.R == c.G && c.G == c.B
+			opaque = opaque && c.A == 0xff
+
+			if len(colors) <= 256 {
+				if _, ok := seen[c]; !ok {
+					seen[c] = struct{}{}
+					colors = append(colors, c)
+				}
+			}
+		}
+	}
+
+	switch {
+	case len(colors) <= maxPackedColors || len(colors) <= 256 && !(gray && opaque):
+		// Translucent colors first keep the tRNS chunk short.
+		slices.SortStableFunc(colors, func(a, b color.NRGBA) int {
+			return boolInt(a.A == 0xff) - boolInt(b.A == 0xff)
+		})
+
+		palette := make(color.Palette, len(colors))
+		index := make(map[color.NRGBA]uint8, len(colors))
+		for i, c := range colors {
+			palette[i] = c
+			index[c] = uint8(i)
+		}
+
+		dst := image.NewPaletted(bounds, palette)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i := nrgba.PixOffset(bounds.Min.X, y)
+			out := dst.Pix[dst.PixOffset(bounds.Min.X, y):]
+			for x, p := 0, nrgba.Pix[i:i+bounds.Dx()*4]; len(p) > 0; x, p = x+1, p[4:] {
+				out[x] = index[transparentNRGBA(p)]
+			}
+		}
+		return dst
+
+	case gray && opaque:
+		if _, ok := src.(*image.Gray); ok {
+			return nil
+		}
+		dst := image.NewGray(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i := nrgba.PixOffset(bounds.Min.X, y)
+			out := dst.Pix[dst.PixOffset(bounds.Min.X, y):]
+			for x := range bounds.Dx() {
+				out[x] = nrgba.Pix[i+x*4]
+			}
+		}
+		return dst
+	}
+
+	if src == image.Image(nrgba) {
+		return nil
+	}
+	return nrgba
+}
+
+// reducePNG16 returns a 16-bit image as *image.NRGBA and true if all its samples
+// fit in 8 bits. Otherwise it returns it as *image.Gray16 if it is opaque and gray,
+// or nil, and false.
+func reducePNG16(img image.Image) (image.Image, bool) {
+	bounds := img.Bounds()
+	row := make([]uint16, bounds.Dx()*4)
+	narrow, gray := true, true
+	for y := bounds.Min.Y; y < bounds.Max.Y && (narrow || gray); y++ {
+		nrgba64Row(img, y, row)
+		for c := row; len(c) > 0; c = c[4:] {
+			narrow = narrow && c[0]>>8 == c[0]&0xff && c[1]>>8 == c[1]&0xff && c[2]>>8 == c[2]&0xff && c[3]>>8 == c[3]&0xff
+			gray = gray && c[0] == c[1] && c[1] == c[2] && c[3] == 0xffff
+		}
+	}
+
+	switch {
+	case narrow:
+		dst := image.NewNRGBA(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			nrgba64Row(img, y, row)
+			out := dst.Pix[dst.PixOffset(bounds.Min.X, y):]
+			for i, v := range row {
+				out[i] = uint8(v)
+			}
+		}
+		return dst, true
+
+	case gray:
+		if _, ok := img.(*image.Gray16); ok {
+			return nil, false
+		}
+		dst := image.NewGray16(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			nrgba64Row(img, y, row)
+			out := dst.Pix[dst.PixOffset(bounds.Min.X, y):]
+			for x := range bounds.Dx() {
+				binary.BigEndian.PutUint16(out[x*2:], row[x*4])
+			}
+		}
+		return dst, false
+	}
+
+	return nil, false
+}
+
+// nrgba64Row reads the row y of an *image.Gray16, *image.NRGBA64 or *image.RGBA64
+// into row as non-premultiplied red, green, blue and alpha samples.
+func nrgba64Row(img image.Image, y int, row []uint16) {
+	switch img := img.(type) {
+	case *image.Gray16:
+		pix := img.Pix[img.PixOffset(img.Rect.Min.X, y):]
+		for i := 0; i < len(row); i += 4 {
+			v := binary.BigEndian.Uint16(pix[i/2:])
+			row[i], row[i+1], row[i+2], row[i+3] = v, v, v, 0xffff
+		}
+
+	case *image.NRGBA64:
+		pix := img.Pix[img.PixOffset(img.Rect.Min.X, y):]
+		for i := range row {
+			row[i] = binary.BigEndian.Uint16(pix[i*2:])
+		}
+
+	case *image.RGBA64:
+		pix := img.Pix[img.PixOffset(img.Rect.Min.X, y):]
+		for i := 0; i < len(row); i += 4 {
+			a := uint32(binary.BigEndian.Uint16(pix[i*2+6:]))
+			for k := range 3 {
+				v := uint32(binary.BigEndian.Uint16(pix[(i+k)*2:]))
+				switch a {
+				case 0:
+					v = 0
+				case 0xffff:
+				default:
+					v = v * 0xffff / a
+				}
+				row[i+k] = uint16(v)
+			}
+			row[i+3] = uint16(a)
+		}
+	}
+}
+
+// transparentNRGBA returns the color of the pixel p, with every fully
+// transparent pixel as transparent black.
+func transparentNRGBA(p []uint8) color.NRGBA {
+	if p[3] == 0 {
+		return color.NRGBA{}
+	}
+	return color.NRGBA{p[0], p[1], p[2], p[3]}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,133 @@
+package superimage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestOptimizedPNGReduces(t *testing.T) {
+	r := image.Rect(0, 0, 32, 32)
+
+	// More than 256 colors, not gray, every sample fits in 8 bits.
+	narrow := image.NewNRGBA64(r)
+	// Same, but with a sample that needs 16 bits.
+	wide := image.NewNRGBA64(r)
+	// Opaque gray with more than 256 levels.
+	gray16 := image.NewRGBA64(r)
+	// Opaque gray with 8-bit levels.
+	gray := image.NewNRGBA(r)
+	// A few colors, some translucent.
+	few := image.NewNRGBA(r)
+	for y := range r.Dy() {
+		for x := range r.Dx() {
+			c := color.NRGBA64{uint16(x*8) * 0x101, uint16(y*8) * 0x101, 0x4040, 0xffff}
+			narrow.SetNRGBA64(x, y, c)
+			wide.SetNRGBA64(x, y, c)
+			g := uint16(x*32 + y)
+			gray16.SetRGBA64(x, y, color.RGBA64{g, g, g, 0xffff})
+			gray.SetNRGBA(x, y, color.NRGBA{uint8(x * 8), uint8(x * 8), uint8(x * 8), 0xff})
+			few.SetNRGBA(x, y, color.NRGBA{uint8(x % 3 * 100), 0x20, 0x30, uint8(0xff - y%2*0x80)})
+		}
+	}
+	wide.SetNRGBA64(3, 3, color.NRGBA64{0x1234, 0, 0, 0xffff})
+
+	tests := []struct {
+		name string
+		img  image.Image
+		// want is the type reducePNG returns, nil if it cannot reduce img.
+		want image.Image
+	}{
+		{"narrow NRGBA64", narrow, &image.NRGBA{}},
+		{"wide NRGBA64", wide, nil},
+		{"gray RGBA64", gray16, &image.Gray16{}},
+		{"gray NRGBA", gray, &image.Gray{}},
+		{"few colors", few, &image.Paletted{}},
+		{"many colors", scannerImages(r, image.Point{})[0].img, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reducePNG(tt.img); fmt.Sprintf("%T", got) != fmt.Sprintf("%T", tt.want) {
+				t.Fatalf("reducePNG returned a %T, want %T", got, tt.want)
+			}
+
+			var buf bytes.Buffer
+			if err := Encode(&buf, tt.img, &EncodeOptions{Format: "png", PngOpts: &PNGOptions{}}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := png.Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSame64(t, got, tt.img)
+		})
+	}
+}
+
+// assertSame64 fails if got and want differ in any 16-bit non-premultiplied pixel.
+func assertSame64(t *testing.T, got, want image.Image) {
+	t.Helper()
+
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g := color.NRGBA64Model.Convert(got.At(x, y))
+			w := color.NRGBA64Model.Convert(want.At(x, y))
+			if g != w {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestOptimizedPNGFilters(t *testing.T) {
+	img := scannerImages(image.Rect(0, 0, 40, 30), image.Point{})[0].img
+	filters := []PNGFilter{PNGFilterAdaptive, PNGFilterNone, PNGFilterSub, PNGFilterUp, PNGFilterAverage, PNGFilterPaeth}
+	for _, filter := range filters {
+		t.Run(fmt.Sprint(filter), func(t *testing.T) {
+			got := roundTrip(t, img, &EncodeOptions{Format: "png", PngOpts: &PNGOptions{Filters: []PNGFilter{filter}}})
+			assertSame64(t, got, img)
+		})
+	}
+
+	err := Encode(&bytes.Buffer{}, img, &EncodeOptions{Format: "png", PngOpts: &PNGOptions{Filters: []PNGFilter{PNGFilterPaeth + 1}}})
+	if err != ErrInvalidMethod {
+		t.Fatalf("unknown filter: err = %v, want %v", err, ErrInvalidMethod)
+	}
+}
+
+func BenchmarkEncodePNG(b *testing.B) {
+	r := image.Rect(0, 0, 512, 384)
+	photo := scannerImages(r, image.Point{})[2].img
+	paletted, _, err := Quantize(photo, 64, MedianCut)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	allFilters := []PNGFilter{PNGFilterAdaptive, PNGFilterNone, PNGFilterSub, PNGFilterUp, PNGFilterAverage, PNGFilterPaeth}
+	encoders := []struct {
+		name string
+		opts *EncodeOptions
+	}{
+		{"image-png", &EncodeOptions{Format: "png"}},
+		{"optimized", &EncodeOptions{Format: "png", PngOpts: &PNGOptions{}}},
+		{"all-filters", &EncodeOptions{Format: "png", PngOpts: &PNGOptions{Filters: allFilters}}},
+	}
+	for _, ti := range []typedImage{{"photo", photo}, {"paletted", paletted}} {
+		for _, e := range encoders {
+			b.Run(ti.name+"/"+e.name, func(b *testing.B) {
+				var buf bytes.Buffer
+				for b.Loop() {
+					buf.Reset()
+					if err := Encode(&buf, ti.img, e.opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(buf.Len()), "bytes/file")
+			})
+		}
+	}
+}
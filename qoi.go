@@ -0,0 +1,189 @@
+package superimage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+// QOI, the "Quite OK Image" format: https://qoiformat.org/qoi-specification.pdf
+const (
+	qoiHeaderSize = 14
+
+	qoiOpIndex = 0x00
+	qoiOpDiff  = 0x40
+	qoiOpLuma  = 0x80
+	qoiOpRun   = 0xc0
+	qoiOpRGB   = 0xfe
+	qoiOpRGBA  = 0xff
+	qoiMask    = 0xc0
+
+	qoiMaxRun = 62
+)
+
+var qoiEnd = []byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+func qoiHash(p [4]uint8) int {
+	return (int(p[0])*3 + int(p[1])*5 + int(p[2])*7 + int(p[3])*11) % 64
+}
+
+// decodeQOI decodes a QOI file into an *image.NRGBA.
+func decodeQOI(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, qoiHeaderSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "qoif" {
+		return nil, formatError("qoi", "bad header")
+	}
+
+	width, height := int(binary.BigEndian.Uint32(header[4:])), int(binary.BigEndian.Uint32(header[8:]))
+	if width <= 0 || height <= 0 || width > maxImagePixels/height {
+		return nil, formatError("qoi", "bad image size")
+	}
+	if channels := header[12]; channels != 3 && channels != 4 {
+		return nil, formatError("qoi", "bad channels")
+	}
+
+	// The pixels are appended as they are decoded, so a header alone cannot force a
+	// huge allocation.
+	size := width * height * 4
+	pix := make([]uint8, 0, min(size, 1<<20))
+	var index [64][4]uint8
+	px := [4]uint8{0, 0, 0, 0xff}
+	run := 0
+
+	for len(pix) < size {
+		if run > 0 {
+			run--
+		} else {
+			b, err := br.ReadByte()
+			if err != nil {
+				return nil, formatError("qoi", "truncated data")
+			}
+
+			switch {
+			case b == qoiOpRGB, b == qoiOpRGBA:
+				n := 3
+				if b == qoiOpRGBA {
+					n = 4
+				}
+				var buf [4]byte
+				if _, err := io.ReadFull(br, buf[:n]); err != nil {
+					return nil, formatError("qoi", "truncated data")
+				}
+				copy(px[:n], buf[:n])
+
+			case b&qoiMask == qoiOpIndex:
+				px = index[b]
+
+			case b&qoiMask == qoiOpDiff:
+				px[0] += b>>4&3 - 2
+				px[1] += b>>2&3 - 2
+				px[2] += b&3 - 2
+
+			case b&qoiMask == qoiOpLuma:
+				b2, err := br.ReadByte()
+				if err != nil {
+					return nil, formatError("qoi", "truncated data")
+				}
+				dg := b&0x3f - 32
+				px[0] += dg - 8 + b2>>4
+				px[1] += dg
+				px[2] += dg - 8 + b2&0xf
+
+			default:
+				run = int(b & 0x3f)
+			}
+
+			index[qoiHash(px)] = px
+		}
+
+		pix = append(pix, px[:]...)
+	}
+
+	return &image.NRGBA{Pix: pix, Stride: width * 4, Rect: image.Rect(0, 0, width, height)}, nil
+}
+
+// encodeQOI writes a QOI file with 3 channels if m is opaque, 4 otherwise.
+func encodeQOI(w io.Writer, m image.Image, _ *EncodeOptions) error {
+	bounds := m.Bounds()
+	if bounds.Empty() {
+		return ErrInvalidSize
+	}
+
+	channels := uint8(4)
+	if isOpaque(m) {
+		channels = 3
+	}
+
+	bw := bufio.NewWriter(w)
+	header := make([]byte, qoiHeaderSize)
+	copy(header, "qoif")
+	binary.BigEndian.PutUint32(header[4:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(header[8:], uint32(bounds.Dy()))
+	header[12] = channels
+	bw.Write(header)
+
+	var index [64][4]uint8
+	prev := [4]uint8{0, 0, 0, 0xff}
+	run := 0
+
+	s := newScanner(m)
+	row := make([]uint8, bounds.Dx()*4)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		s.scan(bounds.Min.X, y, bounds.Max.X, row)
+
+		for x := 0; x < len(row); x += 4 {
+			px := [4]uint8{row[x], row[x+1], row[x+2], row[x+3]}
+
+			if px == prev {
+				run++
+				if run == qoiMaxRun {
+					bw.WriteByte(qoiOpRun | uint8(run-1))
+					run = 0
+				}
+				continue
+			}
+			if run > 0 {
+				bw.WriteByte(qoiOpRun | uint8(run-1))
+				run = 0
+			}
+
+			h := qoiHash(px)
+			switch {
+			case index[h] == px:
+				bw.WriteByte(qoiOpIndex | uint8(h))
+
+			case px[3] != prev[3]:
+				bw.Write([]byte{qoiOpRGBA, px[0], px[1], px[2], px[3]})
+
+			default:
+				dr, dg, db := int8(px[0]-prev[0]), int8(px[1]-prev[1]), int8(px[2]-prev[2])
+				drg, dbg := dr-dg, db-dg
+
+				switch {
+				case dr >= -2 && dr <= 1 && dg >= -2 && dg <= 1 && db >= -2 && db <= 1:
+					bw.WriteByte(qoiOpDiff | uint8(dr+2)<<4 | uint8(dg+2)<<2 | uint8(db+2))
+				case dg >= -32 && dg <= 31 && drg >= -8 && drg <= 7 && dbg >= -8 && dbg <= 7:
+					bw.Write([]byte{qoiOpLuma | uint8(dg+32), uint8(drg+8)<<4 | uint8(dbg+8)})
+				default:
+					bw.Write([]byte{qoiOpRGB, px[0], px[1], px[2]})
+				}
+			}
+
+			index[h] = px
+			prev = px
+		}
+	}
+
+	if run > 0 {
+		bw.WriteByte(qoiOpRun | uint8(run-1))
+	}
+	bw.Write(qoiEnd)
+
+	return bw.Flush()
+}
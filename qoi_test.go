@@ -0,0 +1,49 @@
+package superimage
+
+import (
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestQOIRoundTrip(t *testing.T) {
+	r := image.Rect(3, 2, 67, 41)
+	for _, ti := range scannerImages(r, image.Point{}) {
+		t.Run(ti.name, func(t *testing.T) {
+			got := roundTrip(t, ti.img, &EncodeOptions{Format: "qoi"})
+			assertSameNRGBA(t, got, ti.img)
+		})
+	}
+
+	// Runs, indexes and small differences use the other operations.
+	t.Run("flat", func(t *testing.T) {
+		img := image.NewGray(r)
+		for i := range img.Pix {
+			img.Pix[i] = uint8(i / 100)
+		}
+		got := roundTrip(t, img, &EncodeOptions{Format: "qoi"})
+		assertSameNRGBA(t, got, img)
+	})
+}
+
+func qoiHeader(width, height uint32) []byte {
+	b := []byte("qoif")
+	b = binary.BigEndian.AppendUint32(b, width)
+	b = binary.BigEndian.AppendUint32(b, height)
+	return append(b, 4, 0)
+}
+
+func TestQOIRejectsTruncated(t *testing.T) {
+	tests := map[string][]byte{
+		"header only":  qoiHeader(16000, 16000),
+		"short header": qoiHeader(16000, 16000)[:10],
+		"one run":      append(qoiHeader(16000, 16000), qoiOpRun|61),
+		"too big":      qoiHeader(1<<20, 1<<20),
+		"bad channels": append(qoiHeader(1, 1)[:12], 5, 0),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertRejected(t, "qoi", data)
+		})
+	}
+}
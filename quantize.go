@@ -0,0 +1,443 @@
+package superimage
+
+import (
+	"cmp"
+	"image"
+	"image/color"
+	"slices"
+)
+
+// QuantizeMethod is an algorithm that chooses the palette of an image.
+// It implements draw.Quantizer, so it can be used as gif.Options.Quantizer.
+type QuantizeMethod int
+
+const (
+	// MedianCut splits the colors of the image in boxes, cutting the box with the
+	// biggest error at its median until there are as many boxes as colors.
+	MedianCut QuantizeMethod = iota
+
+	// Octree groups the colors in a tree by their bits and merges its least used
+	// leaves. Alpha is a fourth bit at every level.
+	Octree
+
+	// KMeans refines the palette of MedianCut by moving every color to the mean
+	// of the pixels closest to it. It is the slowest and most accurate method.
+	KMeans
+)
+
+// Limits of the k-means refinement.
+const (
+	kMeansIterations = 16
+	kMeansMaxColors  = 1 << 15
+)
+
+// histEntry is a distinct color of an image and the number of pixels with it.
+type histEntry struct {
+	c     color.NRGBA
+	count int
+}
+
+// Quantize reduces an image to at most n colors, between 1 and 256, chosen by method.
+// It returns a *SuperImage backed by an *image.Paletted, where every pixel has the
+// closest color of the palette, and the palette. Images with n colors or fewer keep
+// their exact colors.
+func Quantize(img image.Image, n int, method QuantizeMethod) (*SuperImage, color.Palette, error) {
+	if n < 1 || n > 256 {
+		return nil, nil, ErrInvalidColors
+	}
+	if method < MedianCut || method > KMeans {
+		return nil, nil, ErrInvalidMethod
+	}
+
+	palette := method.palette(histogram(img), n)
+	paletted, err := mapPalette(img, palette, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newLike(paletted, img), palette, nil
+}
+
+// Quantize appends to p up to cap(p)-len(p) colors chosen for m, implementing
+// draw.Quantizer. Unknown methods use MedianCut.
+func (method QuantizeMethod) Quantize(p color.Palette, m image.Image) color.Palette {
+	n := min(cap(p)-len(p), 256)
+	if n <= 0 {
+		return p
+	}
+
+	return append(p, method.palette(histogram(m), n)...)
+}
+
+// palette chooses at most n colors for the histogram h.
+func (method QuantizeMethod) palette(h []histEntry, n int) color.Palette {
+	var colors []color.NRGBA
+	switch {
+	case len(h) <= n:
+		colors = make([]color.NRGBA, len(h))
+		for i, e := range h {
+			colors[i] = e.c
+		}
+	case method == Octree:
+		colors = octree(h, n)
+	case method == KMeans:
+		h = coarseHistogram(h, kMeansMaxColors)
+		colors = kMeans(h, medianCut(h, n))
+	default:
+		colors = medianCut(h, n)
+	}
+
+	palette := make(color.Palette, len(colors))
+	for i, c := range colors {
+		palette[i] = c
+	}
+	return palette
+}
+
+// histogram returns the distinct colors of img, with every fully transparent pixel
+// as transparent black.
+func histogram(img image.Image) []histEntry {
+	img = unwrap(img)
+	bounds := img.Bounds()
+	s := newScanner(img)
+	row := make([]uint8, bounds.Dx()*4)
+
+	counts := make(map[color.NRGBA]int)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		s.scan(bounds.Min.X, y, bounds.Max.X, row)
+		for p := row; len(p) > 0; p = p[4:] {
+			counts[transparentNRGBA(p)]++
+		}
+	}
+
+	h := make([]histEntry, 0, len(counts))
+	for c, count := range counts {
+		h = append(h, histEntry{c, count})
+	}
+	// Map iteration is random; sorting makes the palettes deterministic.
+	slices.SortFunc(h, func(a, b histEntry) int {
+		return cmp.Compare(nrgbaKey(a.c), nrgbaKey(b.c))
+	})
+	return h
+}
+
+func nrgbaKey(c color.NRGBA) uint32 {
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+}
+
+// coarseHistogram merges the colors of h that differ only in their lowest bits
+// until it has at most max entries. Merged entries have the mean color.
+func coarseHistogram(h []histEntry, max int) []histEntry {
+	for shift := uint(1); len(h) > max && shift < 8; shift++ {
+		type sum struct {
+			c     [4]int
+			count int
+		}
+		buckets := make(map[color.NRGBA]*sum)
+		var keys []color.NRGBA
+
+		for _, e := range h {
+			k := color.NRGBA{e.c.R >> shift, e.c.G >> shift, e.c.B >> shift, e.c.A >> shift}
+			b, ok := buckets[k]
+			if !ok {
+				b = &sum{}
+				buckets[k] = b
+				keys = append(keys, k)
+			}
+			b.c[0] += int(e.c.R) * e.count
+			b.c[1] += int(e.c.G) * e.count
+			b.c[2] += int(e.c.B) * e.count
+			b.c[3] += int(e.c.A) * e.count
+			b.count += e.count
+		}
+
+		merged := make([]histEntry, len(keys))
+		for i, k := range keys {
+			b := buckets[k]
+			merged[i] = histEntry{meanNRGBA(b.c, b.count), b.count}
+		}
+		h = merged
+	}
+	return h
+}
+
+func meanNRGBA(sum [4]int, count int) color.NRGBA {
+	var c [4]uint8
+	for i, s := range sum {
+		c[i] = uint8((s + count/2) / count)
+	}
+	return color.NRGBA{c[0], c[1], c[2], c[3]}
+}
+
+func channel(c color.NRGBA, i int) uint8 {
+	switch i {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	case 2:
+		return c.B
+	default:
+		return c.A
+	}
+}
+
+// medianCut returns the mean colors of n boxes of the histogram h, which has more than n entries.
+func medianCut(h []histEntry, n int) []color.NRGBA {
+	// A box caches the channel whose values are the most spread around their mean,
+	// and the sum of the squared deviations of that channel, its score.
+	type box struct {
+		entries []histEntry
+		count   int
+		channel int
+		score   float64
+	}
+	newBox := func(entries []histEntry) box {
+		b := box{entries: entries}
+		for _, e := range entries {
+			b.count += e.count
+		}
+		for i := range 4 {
+			var sum, sumSq float64
+			for _, e := range entries {
+				v := float64(channel(e.c, i))
+				sum += v * float64(e.count)
+				sumSq += v * v * float64(e.count)
+			}
+			if sse := sumSq - sum*sum/float64(b.count); sse > b.score {
+				b.channel, b.score = i, sse
+			}
+		}
+		return b
+	}
+
+	boxes := []box{newBox(slices.Clone(h))}
+
+	for len(boxes) < n {
+		// The box to cut is the one with the biggest error.
+		best := -1
+		for i, b := range boxes {
+			if len(b.entries) < 2 {
+				continue
+			}
+			if best < 0 || b.score > boxes[best].score {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		b := boxes[best]
+		slices.SortStableFunc(b.entries, func(x, y histEntry) int {
+			return int(channel(x.c, b.channel)) - int(channel(y.c, b.channel))
+		})
+
+		// Cut at the median pixel, leaving at least one color on each side.
+		cut, acc := 1, 0
+		for i, e := range b.entries[:len(b.entries)-1] {
+			acc += e.count
+			cut = i + 1
+			if acc*2 >= b.count {
+				break
+			}
+		}
+
+		boxes[best] = newBox(b.entries[:cut])
+		boxes = append(boxes, newBox(b.entries[cut:]))
+	}
+
+	colors := make([]color.NRGBA, len(boxes))
+	for i, b := range boxes {
+		var sum [4]int
+		for _, e := range b.entries {
+			sum[0] += int(e.c.R) * e.count
+			sum[1] += int(e.c.G) * e.count
+			sum[2] += int(e.c.B) * e.count
+			sum[3] += int(e.c.A) * e.count
+		}
+		colors[i] = meanNRGBA(sum, b.count)
+	}
+	return colors
+}
+
+// octreeNode is a node of the color tree built by octree. Every node holds the
+// sum of the colors below it.
+type octreeNode struct {
+	children [16]*octreeNode
+	sum      [4]int
+	count    int
+	leaf     bool
+}
+
+// octree returns at most n colors for the histogram h, which has more than n entries.
+func octree(h []histEntry, n int) []color.NRGBA {
+	root := &octreeNode{}
+	// Internal nodes by depth, the root at 0.
+	var levels [8][]*octreeNode
+	levels[0] = append(levels[0], root)
+	leaves := 0
+
+	for _, e := range h {
+		node := root
+		for depth := range 8 {
+			node.add(e)
+
+			shift := 7 - depth
+			i := (e.c.R>>shift&1)<<3 | (e.c.G>>shift&1)<<2 | (e.c.B>>shift&1)<<1 | e.c.A>>shift&1
+			child := node.children[i]
+			if child == nil {
+				child = &octreeNode{leaf: depth == 7}
+				node.children[i] = child
+				if child.leaf {
+					leaves++
+				} else {
+					levels[depth+1] = append(levels[depth+1], child)
+				}
+			}
+			node = child
+		}
+		node.add(e)
+	}
+
+	// Merge the least used nodes of the deepest level into their parents' place.
+	for depth := 7; depth >= 0 && leaves > n; depth-- {
+		level := levels[depth]
+		slices.SortStableFunc(level, func(a, b *octreeNode) int { return b.count - a.count })
+
+		for len(level) > 0 && leaves > n {
+			node := level[len(level)-1]
+			level = level[:len(level)-1]
+
+			for i, child := range node.children {
+				if child != nil {
+					leaves--
+					node.children[i] = nil
+				}
+			}
+			node.leaf = true
+			leaves++
+		}
+	}
+
+	var colors []color.NRGBA
+	var walk func(*octreeNode)
+	walk = func(node *octreeNode) {
+		if node.leaf {
+			colors = append(colors, meanNRGBA(node.sum, node.count))
+			return
+		}
+		for _, child := range node.children {
+			if child != nil {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+	return colors
+}
+
+func (node *octreeNode) add(e histEntry) {
+	node.sum[0] += int(e.c.R) * e.count
+	node.sum[1] += int(e.c.G) * e.count
+	node.sum[2] += int(e.c.B) * e.count
+	node.sum[3] += int(e.c.A) * e.count
+	node.count += e.count
+}
+
+// kMeans moves every color of palette to the mean of the entries of h closest to it,
+// until no entry changes of color.
+func kMeans(h []histEntry, palette []color.NRGBA) []color.NRGBA {
+	assigned := make([]int, len(h))
+	for i := range assigned {
+		assigned[i] = -1
+	}
+
+	for range kMeansIterations {
+		changed := false
+		for i, e := range h {
+			if j := nearestNRGBA(palette, e.c); j != assigned[i] {
+				assigned[i], changed = j, true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([][4]int, len(palette))
+		counts := make([]int, len(palette))
+		for i, e := range h {
+			j := assigned[i]
+			sums[j][0] += int(e.c.R) * e.count
+			sums[j][1] += int(e.c.G) * e.count
+			sums[j][2] += int(e.c.B) * e.count
+			sums[j][3] += int(e.c.A) * e.count
+			counts[j] += e.count
+		}
+		for j := range palette {
+			// Colors that lost all their pixels stay where they are.
+			if counts[j] > 0 {
+				palette[j] = meanNRGBA(sums[j], counts[j])
+			}
+		}
+	}
+
+	return palette
+}
+
+// nearestNRGBA returns the index of the color of palette closest to c.
+func nearestNRGBA(palette []color.NRGBA, c color.NRGBA) int {
+	best, bestDist := 0, int(^uint(0)>>1)
+	for i, p := range palette {
+		dr, dg, db, da := int(c.R)-int(p.R), int(c.G)-int(p.G), int(c.B)-int(p.B), int(c.A)-int(p.A)
+		if d := dr*dr + dg*dg + db*db + da*da; d < bestDist {
+			best, bestDist = i, d
+			if d == 0 {
+				break
+			}
+		}
+	}
+	return best
+}
+
+// paletteColors returns the colors of palette as non-premultiplied 8-bit colors.
+func paletteColors(palette color.Palette) []color.NRGBA {
+	colors := make([]color.NRGBA, len(palette))
+	for i, c := range palette {
+		colors[i] = color.NRGBAModel.Convert(c).(color.NRGBA)
+	}
+	return colors
+}
+
+// mapPalette returns img as an *image.Paletted with the closest color of palette
+// for every pixel. It keeps the bounds of img.
+func mapPalette(img image.Image, palette color.Palette, opts *Options) (*image.Paletted, error) {
+	img = unwrap(img)
+	bounds := img.Bounds()
+	dst := image.NewPaletted(bounds, palette)
+	colors := paletteColors(palette)
+	s := newScanner(img)
+
+	err := parallelFor(opts, bounds.Dy(), func(startY, endY int) {
+		row := make([]uint8, bounds.Dx()*4)
+		// Images repeat colors a lot.
+		cache := make(map[color.NRGBA]uint8)
+
+		for y := bounds.Min.Y + startY; y < bounds.Min.Y+endY; y++ {
+			s.scan(bounds.Min.X, y, bounds.Max.X, row)
+			out := dst.Pix[dst.PixOffset(bounds.Min.X, y):]
+
+			for x, p := 0, row; len(p) > 0; x, p = x+1, p[4:] {
+				c := transparentNRGBA(p)
+				i, ok := cache[c]
+				if !ok {
+					i = uint8(nearestNRGBA(colors, c))
+					cache[c] = i
+				}
+				out[x] = i
+			}
+		}
+	})
+
+	return dst, err
+}
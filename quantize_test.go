@@ -0,0 +1,118 @@
+package superimage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestQuantizePaletteSize(t *testing.T) {
+	img := scannerImages(image.Rect(0, 0, 64, 48), image.Point{})[0].img
+	for _, method := range []QuantizeMethod{MedianCut, Octree, KMeans} {
+		for _, n := range []int{1, 2, 16, 255, 256} {
+			t.Run(fmt.Sprintf("%d/%d", method, n), func(t *testing.T) {
+				q, palette, err := Quantize(img, n, method)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(palette) < 1 || len(palette) > n {
+					t.Fatalf("palette has %d colors, want 1 to %d", len(palette), n)
+				}
+				p, ok := q.Image.(*image.Paletted)
+				if !ok {
+					t.Fatalf("quantized a %T, want *image.Paletted", q.Image)
+				}
+				if p.Bounds() != img.Bounds() {
+					t.Fatalf("bounds = %v, want %v", p.Bounds(), img.Bounds())
+				}
+				for _, i := range p.Pix {
+					if int(i) >= len(palette) {
+						t.Fatalf("index %d out of a palette of %d colors", i, len(palette))
+					}
+				}
+			})
+		}
+	}
+
+	for _, n := range []int{0, 257} {
+		if _, _, err := Quantize(img, n, MedianCut); err != ErrInvalidColors {
+			t.Errorf("%d colors: err = %v, want %v", n, err, ErrInvalidColors)
+		}
+	}
+	if _, _, err := Quantize(img, 16, KMeans+1); err != ErrInvalidMethod {
+		t.Errorf("unknown method: err = %v, want %v", err, ErrInvalidMethod)
+	}
+}
+
+func TestQuantizeKeepsFewColors(t *testing.T) {
+	few := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	for y := range 10 {
+		for x := range 20 {
+			few.SetNRGBA(x, y, color.NRGBA{uint8(x % 4 * 60), 0x10, uint8(y % 2 * 0xff), 0xff})
+		}
+	}
+	for _, method := range []QuantizeMethod{MedianCut, Octree, KMeans} {
+		q, palette, err := Quantize(few, 8, method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(palette) != 8 {
+			t.Fatalf("method %d: palette has %d colors, want 8", method, len(palette))
+		}
+		assertSameNRGBA(t, q, few)
+	}
+}
+
+func TestEncodeGIFQuantizes(t *testing.T) {
+	img := scannerImages(image.Rect(0, 0, 64, 48), image.Point{})[0].img
+	// Some transparent pixels, which need a transparent color of their own.
+	translucent := image.NewNRGBA(img.Bounds())
+	copy(translucent.Pix, img.(*image.NRGBA).Pix)
+	for x := range 10 {
+		translucent.SetNRGBA(x, 0, color.NRGBA{})
+	}
+
+	tests := []struct {
+		name string
+		opts *gif.Options
+		max  int
+	}{
+		{"default", nil, 256},
+		{"16 colors", &gif.Options{NumColors: 16}, 16},
+		{"quantizer", &gif.Options{NumColors: 32, Quantizer: Octree}, 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, translucent, &EncodeOptions{Format: "gif", GifOpts: tt.opts}); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := gif.Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := decoded.(*image.Paletted)
+			if len(p.Palette) > tt.max {
+				t.Fatalf("palette has %d colors, want at most %d", len(p.Palette), tt.max)
+			}
+			if tt.opts == nil || tt.opts.Quantizer == nil {
+				if _, _, _, a := p.At(0, 0).RGBA(); a != 0 {
+					t.Fatal("transparent pixel encoded opaque")
+				}
+			}
+		})
+	}
+
+	// Images that fit in the palette keep their exact colors.
+	few := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	for y := range 10 {
+		for x := range 20 {
+			few.SetNRGBA(x, y, color.NRGBA{uint8(x * 12), 0x10, uint8(y * 20), 0xff})
+		}
+	}
+	got := roundTrip(t, few, &EncodeOptions{Format: "gif"})
+	assertSameNRGBA(t, got, few)
+}
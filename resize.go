@@ -0,0 +1,226 @@
+package superimage
+
+import (
+	"image"
+	"math"
+)
+
+// ResampleFilter is the kernel used by Resize to weight the source pixels.
+type ResampleFilter struct {
+	// Support is the radius of the kernel, in source pixels when the image is enlarged.
+	// Zero means nearest neighbor sampling and Kernel is not used.
+	Support float64
+
+	// Kernel returns the weight of a sample at distance x from the center.
+	Kernel func(x float64) float64
+}
+
+var (
+	// Nearest takes the closest source pixel. Fast and sharp, but blocky.
+	Nearest = ResampleFilter{}
+
+	// Bilinear uses a triangle kernel of support 1.
+	Bilinear = ResampleFilter{Support: 1, Kernel: func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 1 {
+			return 1 - x
+		}
+		return 0
+	}}
+
+	// CatmullRom is a sharp bicubic filter of support 2.
+	CatmullRom = ResampleFilter{Support: 2, Kernel: catmullRom}
+
+	// MitchellNetravali is a bicubic filter of support 2 that is smoother than CatmullRom
+	// and rings less.
+	MitchellNetravali = ResampleFilter{Support: 2, Kernel: func(x float64) float64 {
+		return bicubicKernel(x, 1.0/3, 1.0/3)
+	}}
+
+	// Bicubic is the same as CatmullRom.
+	Bicubic = CatmullRom
+
+	// Lanczos3 is a windowed sinc filter of support 3. It gives the sharpest results
+	// and is the slowest.
+	Lanczos3 = ResampleFilter{Support: 3, Kernel: func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 3 {
+			return sinc(x) * sinc(x/3)
+		}
+		return 0
+	}}
+)
+
+// bicubicKernel is the cubic filter family of Mitchell and Netravali with parameters b and c.
+func bicubicKernel(x, b, c float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+	case x < 2:
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	default:
+		return 0
+	}
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// Resize scales an image to width x height pixels using the given filter.
+// If one of width or height is zero, it is derived from the other keeping the aspect ratio.
+// If both are zero or one is negative, it returns an error.
+// The result bounds start at (0, 0).
+func Resize(img image.Image, width, height int, filter ResampleFilter) (*SuperImage, error) {
+	resized, err := resize(nil, img, width, height, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLike(resized, img), nil
+}
+
+// resizeDimensions validates the requested size and fills a zero dimension from the aspect ratio.
+func resizeDimensions(bounds image.Rectangle, width, height int) (int, int, error) {
+	if width < 0 || height < 0 || width == 0 && height == 0 {
+		return 0, 0, ErrInvalidSize
+	}
+
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 {
+		return width, height, nil
+	}
+
+	if width == 0 {
+		width = max(1, int(math.Round(float64(srcW)*float64(height)/float64(srcH))))
+	}
+	if height == 0 {
+		height = max(1, int(math.Round(float64(srcH)*float64(width)/float64(srcW))))
+	}
+
+	return width, height, nil
+}
+
+func resize(dst *image.NRGBA, img image.Image, width, height int, filter ResampleFilter, opts *Options) (*image.NRGBA, error) {
+	bounds := img.Bounds()
+	width, height, err := resizeDimensions(bounds, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	resized := reuseNRGBA(dst, image.Rect(0, 0, width, height))
+	if bounds.Empty() || width == 0 || height == 0 {
+		return resized, nil
+	}
+
+	src, err := toNRGBA(img, opts.stage(0, 3))
+	if err != nil {
+		return nil, err
+	}
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	// Horizontal pass: srcW x srcH to width x srcH, premultiplied.
+	cols := resampleWeights(srcW, width, filter)
+	tmp := make([]float32, width*srcH*4)
+	err = parallelFor(opts.stage(1, 3), srcH, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			i := src.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			row := src.Pix[i : i+srcW*4]
+			out := tmp[y*width*4 : (y+1)*width*4]
+
+			for x, w := range cols {
+				var r, g, b, a float32
+				for k, weight := range w.weights {
+					p := row[(w.start+k)*4 : (w.start+k)*4+4 : (w.start+k)*4+4]
+					pa := float32(p[3]) * weight
+					r += float32(p[0]) * pa
+					g += float32(p[1]) * pa
+					b += float32(p[2]) * pa
+					a += pa
+				}
+				o := out[x*4 : x*4+4 : x*4+4]
+				o[0], o[1], o[2], o[3] = r/0xff, g/0xff, b/0xff, a
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Vertical pass: width x srcH to width x height.
+	rows := resampleWeights(srcH, height, filter)
+	err = parallelFor(opts.stage(2, 3), height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			w := rows[y]
+			i := resized.PixOffset(0, y)
+			out := resized.Pix[i : i+width*4]
+
+			for x := range width {
+				var c [4]float64
+				for k, weight := range w.weights {
+					p := tmp[((w.start+k)*width+x)*4 : ((w.start+k)*width+x)*4+4 : ((w.start+k)*width+x)*4+4]
+					c[0] += float64(p[0] * weight)
+					c[1] += float64(p[1] * weight)
+					c[2] += float64(p[2] * weight)
+					c[3] += float64(p[3] * weight)
+				}
+				storePremultiplied(out[x*4:x*4+4:x*4+4], c)
+			}
+		}
+	})
+
+	return resized, err
+}
+
+// resampleWeight holds the contributions of consecutive source pixels to one destination pixel.
+type resampleWeight struct {
+	start   int
+	weights []float32
+}
+
+// resampleWeights computes, for every destination pixel of a dstSize line,
+// the normalized weights of the srcSize source pixels that contribute to it.
+func resampleWeights(srcSize, dstSize int, filter ResampleFilter) []resampleWeight {
+	scale := float64(srcSize) / float64(dstSize)
+	out := make([]resampleWeight, dstSize)
+
+	if filter.Support == 0 || filter.Kernel == nil {
+		for i := range out {
+			j := min(int((float64(i)+0.5)*scale), srcSize-1)
+			out[i] = resampleWeight{start: j, weights: []float32{1}}
+		}
+		return out
+	}
+
+	// When shrinking, the kernel is stretched so every source pixel contributes.
+	stretch := max(scale, 1)
+	support := filter.Support * stretch
+
+	for i := range out {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := max(int(math.Ceil(center-support)), 0)
+		end := min(int(math.Floor(center+support)), srcSize-1)
+
+		weights := make([]float32, 0, end-start+1)
+		var sum float64
+		for j := start; j <= end; j++ {
+			w := filter.Kernel((float64(j) - center) / stretch)
+			weights = append(weights, float32(w))
+			sum += w
+		}
+
+		if sum != 0 {
+			for k := range weights {
+				weights[k] = float32(float64(weights[k]) / sum)
+			}
+		}
+		out[i] = resampleWeight{start: start, weights: weights}
+	}
+
+	return out
+}
@@ -0,0 +1,92 @@
+package superimage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+var resampleFilters = []struct {
+	name   string
+	filter ResampleFilter
+}{
+	{"Nearest", Nearest},
+	{"Bilinear", Bilinear},
+	{"CatmullRom", CatmullRom},
+	{"MitchellNetravali", MitchellNetravali},
+	{"Lanczos3", Lanczos3},
+}
+
+func TestResizeDimensions(t *testing.T) {
+	img := scannerImages(image.Rect(5, -5, 105, 45), image.Point{})[0].img
+	tests := []struct {
+		width, height int
+		want          image.Point
+	}{
+		{40, 30, image.Pt(40, 30)},
+		{300, 7, image.Pt(300, 7)},
+		{50, 0, image.Pt(50, 25)},
+		{0, 100, image.Pt(200, 100)},
+		{1, 0, image.Pt(1, 1)},
+	}
+	for _, f := range resampleFilters {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/%dx%d", f.name, tt.width, tt.height), func(t *testing.T) {
+				got, err := Resize(img, tt.width, tt.height, f.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Bounds() != (image.Rectangle{Max: tt.want}) {
+					t.Fatalf("bounds = %v, want size %v from (0,0)", got.Bounds(), tt.want)
+				}
+			})
+		}
+	}
+
+	for _, size := range [][2]int{{0, 0}, {-1, 10}, {10, -1}} {
+		if _, err := Resize(img, size[0], size[1], Bilinear); err != ErrInvalidSize {
+			t.Errorf("Resize to %dx%d: err = %v, want %v", size[0], size[1], err, ErrInvalidSize)
+		}
+	}
+}
+
+func TestResizeKeepsUniformColor(t *testing.T) {
+	c := color.NRGBA{0x30, 0x90, 0xe0, 0x80}
+	img := image.NewNRGBA(image.Rect(0, 0, 37, 23))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+
+	for _, f := range resampleFilters {
+		for _, size := range []image.Point{{11, 7}, {37, 23}, {90, 50}} {
+			got, err := Resize(img, size.X, size.Y, f.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y := range size.Y {
+				for x := range size.X {
+					g := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+					if diff(g.R, c.R) > 1 || diff(g.G, c.G) > 1 || diff(g.B, c.B) > 1 || diff(g.A, c.A) > 1 {
+						t.Fatalf("%s to %v: pixel (%d, %d) = %v, want %v", f.name, size, x, y, g, c)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestResizeSameSize(t *testing.T) {
+	img := scannerImages(image.Rect(0, 0, 30, 20), image.Point{})[0].img
+	got, err := Resize(img, 30, 20, Nearest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameNRGBA(t, got, img)
+}
+
+func diff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,132 @@
+package superimage
+
+import (
+	"image"
+	"image/color"
+)
+
+// scanner reads rows of an image as non-premultiplied 8-bit RGBA, the layout
+// of image.NRGBA. The common concrete types are read straight from their Pix
+// slices; any other image falls back to At.
+type scanner struct {
+	img     image.Image
+	palette [][4]uint8
+}
+
+func newScanner(img image.Image) *scanner {
+	s := &scanner{img: unwrap(img)}
+
+	if p, ok := s.img.(*image.Paletted); ok {
+		s.palette = make([][4]uint8, len(p.Palette))
+		for i, c := range p.Palette {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			s.palette[i] = [4]uint8{n.R, n.G, n.B, n.A}
+		}
+	}
+
+	return s
+}
+
+// scan writes the pixels from (x1, y) to (x2, y) into dst, which must hold
+// at least 4*(x2-x1) bytes. Coordinates are absolute and must lie inside the bounds.
+func (s *scanner) scan(x1, y, x2 int, dst []uint8) {
+	switch img := s.img.(type) {
+	case *image.NRGBA:
+		i := img.PixOffset(x1, y)
+		copy(dst, img.Pix[i:i+(x2-x1)*4])
+
+	case *image.RGBA:
+		i := img.PixOffset(x1, y)
+		src := img.Pix[i : i+(x2-x1)*4]
+		for j := 0; j < len(src); j += 4 {
+			d := dst[j : j+4 : j+4]
+			p := src[j : j+4 : j+4]
+			switch a := p[3]; a {
+			case 0xff:
+				d[0], d[1], d[2], d[3] = p[0], p[1], p[2], a
+			case 0:
+				d[0], d[1], d[2], d[3] = 0, 0, 0, 0
+			default:
+				d[0] = unpremultiply(p[0], a)
+				d[1] = unpremultiply(p[1], a)
+				d[2] = unpremultiply(p[2], a)
+				d[3] = a
+			}
+		}
+
+	case *image.YCbCr:
+		for x, j := x1, 0; x < x2; x, j = x+1, j+4 {
+			yi := img.YOffset(x, y)
+			ci := img.COffset(x, y)
+			r, g, b := color.YCbCrToRGB(img.Y[yi], img.Cb[ci], img.Cr[ci])
+			d := dst[j : j+4 : j+4]
+			d[0], d[1], d[2], d[3] = r, g, b, 0xff
+		}
+
+	case *image.Paletted:
+		i := img.PixOffset(x1, y)
+		for _, idx := range img.Pix[i : i+(x2-x1)] {
+			d := dst[:4:4]
+			if int(idx) < len(s.palette) {
+				c := s.palette[idx]
+				d[0], d[1], d[2], d[3] = c[0], c[1], c[2], c[3]
+			} else {
+				d[0], d[1], d[2], d[3] = 0, 0, 0, 0
+			}
+			dst = dst[4:]
+		}
+
+	case *image.Gray:
+		i := img.PixOffset(x1, y)
+		for j, v := range img.Pix[i : i+(x2-x1)] {
+			d := dst[j*4 : j*4+4 : j*4+4]
+			d[0], d[1], d[2], d[3] = v, v, v, 0xff
+		}
+
+	default:
+		for x, j := x1, 0; x < x2; x, j = x+1, j+4 {
+			r, g, b, a := img.At(x, y).RGBA()
+			d := dst[j : j+4 : j+4]
+			if a == 0 {
+				d[0], d[1], d[2], d[3] = 0, 0, 0, 0
+				continue
+			}
+			if a != 0xffff {
+				r = r * 0xffff / a
+				g = g * 0xffff / a
+				b = b * 0xffff / a
+			}
+			d[0], d[1], d[2], d[3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(a>>8)
+		}
+	}
+}
+
+// unpremultiply converts an 8-bit premultiplied channel to non-premultiplied,
+// rounding like color.NRGBAModel.
+func unpremultiply(c, a uint8) uint8 {
+	c32 := uint32(c) * 0x101
+	a32 := uint32(a) * 0x101
+	return uint8((c32 * 0xffff / a32) >> 8)
+}
+
+// toNRGBA returns img as an *image.NRGBA with the same bounds, converting it
+// in parallel if needed. An *image.NRGBA is returned as it is, without copying.
+func toNRGBA(img image.Image, opts *Options) (*image.NRGBA, error) {
+	img = unwrap(img)
+	if n, ok := img.(*image.NRGBA); ok {
+		return n, nil
+	}
+
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(bounds)
+	s := newScanner(img)
+
+	err := parallelFor(opts, bounds.Dy(), func(startY, endY int) {
+		for y := bounds.Min.Y + startY; y < bounds.Min.Y+endY; y++ {
+			i := dst.PixOffset(bounds.Min.X, y)
+			s.scan(bounds.Min.X, y, bounds.Max.X, dst.Pix[i:i+bounds.Dx()*4])
+		}
+	})
+
+	return dst, err
+}
@@ -0,0 +1,139 @@
+package superimage
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+)
+
+// Default quality range searched by EncodeToSize.
+const (
+	defaultMinQuality = 10
+	defaultMaxQuality = 95
+)
+
+// SizeOptions controls how EncodeToSize fits an image in a byte budget.
+type SizeOptions struct {
+	// MinQuality and MaxQuality bound the JPEG quality searched, between 1 and 100.
+	// Zero means 10 and 95.
+	MinQuality int
+	MaxQuality int
+
+	// Downscale scales the image down when it does not fit even at MinQuality,
+	// instead of failing with ErrTargetSize.
+	Downscale bool
+
+	// Filter used to downscale. The zero value means CatmullRom.
+	Filter ResampleFilter
+
+	// StripMetadata drops the metadata of a SuperImage, which counts in its size.
+	StripMetadata bool
+}
+
+// SizeResult describes the JPEG written by EncodeToSize.
+type SizeResult struct {
+	// Quality is the JPEG quality chosen.
+	Quality int
+
+	// Size is the number of bytes written.
+	Size int
+
+	// Width and Height of the encoded image, smaller than the input if it was downscaled.
+	Width, Height int
+}
+
+// EncodeToSize writes img as the best quality JPEG that takes at most maxBytes.
+// The quality is found by binary search. If even the minimum quality is too big,
+// the image is downscaled when opts.Downscale is set, or ErrTargetSize is returned
+// and nothing is written. A nil opts uses the defaults.
+func EncodeToSize(w io.Writer, img image.Image, maxBytes int, opts *SizeOptions) (*SizeResult, error) {
+	if opts == nil {
+		opts = &SizeOptions{}
+	}
+	if maxBytes <= 0 {
+		return nil, ErrTargetSize
+	}
+
+	minQ, maxQ := opts.MinQuality, opts.MaxQuality
+	if minQ == 0 {
+		minQ = defaultMinQuality
+	}
+	if maxQ == 0 {
+		maxQ = defaultMaxQuality
+	}
+	if minQ < 1 || maxQ > 100 || minQ > maxQ {
+		return nil, ErrInvalidQuality
+	}
+
+	filter := opts.Filter
+	if filter.Kernel == nil && filter.Support == 0 {
+		filter = CatmullRom
+	}
+
+	encode := func(img image.Image, quality int) (*bytes.Buffer, error) {
+		buf := new(bytes.Buffer)
+		err := Encode(buf, img, &EncodeOptions{
+			Format:        "jpeg",
+			JpegOpts:      &jpeg.Options{Quality: quality},
+			StripMetadata: opts.StripMetadata,
+		})
+		return buf, err
+	}
+
+	// Scale the image down until it fits at the minimum quality. Every step resizes
+	// the original image, so the losses of the previous steps do not add up.
+	src := img
+	best, err := encode(img, minQ)
+	if err != nil {
+		return nil, err
+	}
+	for best.Len() > maxBytes {
+		bounds := img.Bounds()
+		if !opts.Downscale || bounds.Dx() <= 1 && bounds.Dy() <= 1 {
+			return nil, ErrTargetSize
+		}
+
+		// The size of a JPEG grows about linearly with its area. Scale by at
+		// most 0.9 so a bad estimate costs more steps instead of a too small image.
+		scale := min(0.9, math.Sqrt(float64(maxBytes)/float64(best.Len())))
+		width := max(1, int(float64(bounds.Dx())*scale))
+		height := max(1, int(float64(bounds.Dy())*scale))
+		resized, err := Resize(src, width, height, filter)
+		if err != nil {
+			return nil, err
+		}
+		img = resized
+
+		if best, err = encode(img, minQ); err != nil {
+			return nil, err
+		}
+	}
+
+	// Highest quality that fits.
+	quality := minQ
+	lo, hi := minQ+1, maxQ
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		buf, err := encode(img, mid)
+		if err != nil {
+			return nil, err
+		}
+
+		if buf.Len() <= maxBytes {
+			best, quality = buf, mid
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	n, err := w.Write(best.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	return &SizeResult{Quality: quality, Size: n, Width: bounds.Dx(), Height: bounds.Dy()}, nil
+}
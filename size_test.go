@@ -0,0 +1,97 @@
+package superimage
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand/v2"
+	"testing"
+)
+
+// noisyImage returns an image that compresses badly, so its JPEG size depends a
+// lot on the quality.
+func noisyImage(width, height int) *image.NRGBA {
+	r := rand.New(rand.NewPCG(1, 2))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(r.IntN(256)), uint8(x), uint8(r.IntN(64) + y), 0xff})
+		}
+	}
+	return img
+}
+
+func jpegSize(t *testing.T, img image.Image, quality int) int {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Len()
+}
+
+func TestEncodeToSize(t *testing.T) {
+	img := noisyImage(128, 96)
+	smallest := jpegSize(t, img, defaultMinQuality)
+	biggest := jpegSize(t, img, defaultMaxQuality)
+
+	for _, maxBytes := range []int{smallest, (smallest + biggest) / 2, biggest - 1, biggest * 2} {
+		var buf bytes.Buffer
+		res, err := EncodeToSize(&buf, img, maxBytes, nil)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", maxBytes, err)
+		}
+		if res.Size != buf.Len() || res.Size > maxBytes {
+			t.Fatalf("%d bytes: wrote %d bytes and reported %d", maxBytes, buf.Len(), res.Size)
+		}
+		if res.Width != 128 || res.Height != 96 {
+			t.Fatalf("%d bytes: size = %dx%d, want 128x96", maxBytes, res.Width, res.Height)
+		}
+		// The quality is the best that fits.
+		if res.Quality < defaultMaxQuality && jpegSize(t, img, res.Quality+1) <= maxBytes {
+			t.Fatalf("%d bytes: quality %d, but %d fits too", maxBytes, res.Quality, res.Quality+1)
+		}
+	}
+}
+
+func TestEncodeToSizeTooSmall(t *testing.T) {
+	img := noisyImage(256, 192)
+	maxBytes := jpegSize(t, img, defaultMinQuality) / 3
+
+	var buf bytes.Buffer
+	if _, err := EncodeToSize(&buf, img, maxBytes, nil); err != ErrTargetSize || buf.Len() != 0 {
+		t.Fatalf("err = %v and %d bytes written, want %v and none", err, buf.Len(), ErrTargetSize)
+	}
+
+	res, err := EncodeToSize(&buf, img, maxBytes, &SizeOptions{Downscale: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Size != buf.Len() || res.Size > maxBytes {
+		t.Fatalf("wrote %d bytes and reported %d, want at most %d", buf.Len(), res.Size, maxBytes)
+	}
+	if res.Width >= 256 || res.Height >= 192 {
+		t.Fatalf("size = %dx%d, want smaller than 256x192", res.Width, res.Height)
+	}
+	decoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds().Dx() != res.Width || decoded.Bounds().Dy() != res.Height {
+		t.Fatalf("decoded %v, want %dx%d", decoded.Bounds().Size(), res.Width, res.Height)
+	}
+}
+
+func TestEncodeToSizeInvalid(t *testing.T) {
+	img := noisyImage(8, 8)
+	if _, err := EncodeToSize(&bytes.Buffer{}, img, 0, nil); err != ErrTargetSize {
+		t.Errorf("0 bytes: err = %v, want %v", err, ErrTargetSize)
+	}
+	for _, opts := range []*SizeOptions{{MinQuality: -1}, {MaxQuality: 101}, {MinQuality: 80, MaxQuality: 50}} {
+		if _, err := EncodeToSize(&bytes.Buffer{}, img, 1<<20, opts); err != ErrInvalidQuality {
+			t.Errorf("qualities %d to %d: err = %v, want %v", opts.MinQuality, opts.MaxQuality, err, ErrInvalidQuality)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package superimage
+
+import (
+	"image"
+	"math"
+)
+
+// smartCropAnalysisSize is the longest side of the copy of the image scored by SmartCrop.
+const smartCropAnalysisSize = 256
+
+// Weights of every heuristic in the importance of a pixel.
+const (
+	smartCropEdgeWeight       = 1.0
+	smartCropSkinWeight       = 1.8
+	smartCropSaturationWeight = 0.3
+)
+
+// smartCropSkinColor is the normalized direction of an average skin tone in RGB.
+var smartCropSkinColor = [3]float64{0.7347, 0.5369, 0.4144}
+
+// SmartCrop crops an image to the aspect ratio of width x height keeping its most
+// interesting part, and scales the crop to width x height. It returns the chosen
+// rectangle, in the coordinates of img, and the result.
+//
+// Every pixel is scored by its edge density, saturation and closeness to skin tones,
+// and the biggest window with the target aspect ratio that holds the highest score wins.
+func SmartCrop(img image.Image, width, height int) (image.Rectangle, *SuperImage, error) {
+	if width <= 0 || height <= 0 {
+		return image.Rectangle{}, nil, ErrInvalidSize
+	}
+
+	rect, err := smartCropRect(img, width, height, nil)
+	if err != nil {
+		return image.Rectangle{}, nil, err
+	}
+
+	cropped, err := Resize(subImage(img, rect), width, height, CatmullRom)
+	if err != nil {
+		return image.Rectangle{}, nil, err
+	}
+
+	return rect, cropped, nil
+}
+
+// smartCropRect returns the best rectangle of img with the aspect ratio of width x height.
+func smartCropRect(img image.Image, width, height int, opts *Options) (image.Rectangle, error) {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return bounds, nil
+	}
+
+	// Biggest crop with the target aspect ratio in source coordinates.
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	cropW, cropH := srcW, srcH
+	if srcW*height > srcH*width {
+		cropW = min(srcW, max(1, int(math.Round(float64(srcH)*float64(width)/float64(height)))))
+	} else {
+		cropH = min(srcH, max(1, int(math.Round(float64(srcW)*float64(height)/float64(width)))))
+	}
+	if cropW == srcW && cropH == srcH {
+		return bounds, nil
+	}
+
+	// Score a small copy of the image, the result is the same at a fraction of the cost.
+	scale := min(1, float64(smartCropAnalysisSize)/float64(max(srcW, srcH)))
+	aw := max(1, int(math.Round(float64(srcW)*scale)))
+	ah := max(1, int(math.Round(float64(srcH)*scale)))
+	small, err := resize(nil, img, aw, ah, Bilinear, opts.stage(0, 2))
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+
+	scores, err := smartCropScores(small, opts.stage(1, 2))
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+
+	// Summed-area table of the scores, so the score of any window costs four reads.
+	sat := make([]float64, (aw+1)*(ah+1))
+	for y := range ah {
+		var row float64
+		for x := range aw {
+			row += scores[y*aw+x]
+			sat[(y+1)*(aw+1)+x+1] = sat[y*(aw+1)+x+1] + row
+		}
+	}
+	windowSum := func(x0, y0, x1, y1 int) float64 {
+		return sat[y1*(aw+1)+x1] - sat[y0*(aw+1)+x1] - sat[y1*(aw+1)+x0] + sat[y0*(aw+1)+x0]
+	}
+
+	// The window fills one side of the image, so it only slides along the other.
+	winW := min(aw, max(1, int(math.Round(float64(cropW)*float64(aw)/float64(srcW)))))
+	winH := min(ah, max(1, int(math.Round(float64(cropH)*float64(ah)/float64(srcH)))))
+	slackX, slackY := aw-winW, ah-winH
+
+	bestX, bestY, bestScore := 0, 0, math.Inf(-1)
+	for y := 0; y <= slackY; y++ {
+		for x := 0; x <= slackX; x++ {
+			score := windowSum(x, y, x+winW, y+winH)
+
+			// Ties, like flat images, go to the centered window.
+			dx := float64(x) - float64(slackX)/2
+			dy := float64(y) - float64(slackY)/2
+			score -= 1e-6 * (dx*dx + dy*dy)
+
+			if score > bestScore {
+				bestX, bestY, bestScore = x, y, score
+			}
+		}
+	}
+
+	// Back to source coordinates.
+	x0 := bounds.Min.X
+	if slackX > 0 {
+		x0 += int(math.Round(float64(bestX) / float64(slackX) * float64(srcW-cropW)))
+	}
+	y0 := bounds.Min.Y
+	if slackY > 0 {
+		y0 += int(math.Round(float64(bestY) / float64(slackY) * float64(srcH-cropH)))
+	}
+
+	return image.Rect(x0, y0, x0+cropW, y0+cropH), nil
+}
+
+// smartCropScores computes the importance of every pixel of img.
+func smartCropScores(img *image.NRGBA, opts *Options) ([]float64, error) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+
+	luma := make([]float64, width*height)
+	err := parallelFor(opts.stage(0, 2), height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := range width {
+				p := img.Pix[img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y):]
+				luma[y*width+x] = (0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])) / 0xff
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	lumaAt := func(x, y int) float64 {
+		x = min(max(x, 0), width-1)
+		y = min(max(y, 0), height-1)
+		return luma[y*width+x]
+	}
+
+	scores := make([]float64, width*height)
+	err = parallelFor(opts.stage(1, 2), height, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := range width {
+				i := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+				p := img.Pix[i : i+4 : i+4]
+				r, g, b := float64(p[0])/0xff, float64(p[1])/0xff, float64(p[2])/0xff
+				l := luma[y*width+x]
+
+				// Edges: absolute Laplacian of the luma.
+				edge := math.Abs(4*l - lumaAt(x-1, y) - lumaAt(x+1, y) - lumaAt(x, y-1) - lumaAt(x, y+1))
+
+				// Skin: closeness of the color direction to the skin tone, for mid lightness.
+				var skin float64
+				if mag := math.Sqrt(r*r + g*g + b*b); mag > 0 && l > 0.2 && l < 0.95 {
+					dr := r/mag - smartCropSkinColor[0]
+					dg := g/mag - smartCropSkinColor[1]
+					db := b/mag - smartCropSkinColor[2]
+					skin = max(0, 1-math.Sqrt(dr*dr+dg*dg+db*db)-0.8) / 0.2
+				}
+
+				// Saturation as in HSL, ignoring almost black and white pixels.
+				var saturation float64
+				if l > 0.05 && l < 0.9 {
+					hi, lo := max(r, g, b), min(r, g, b)
+					if sum := hi + lo; hi != lo {
+						if sum > 1 {
+							saturation = (hi - lo) / (2 - sum)
+						} else {
+							saturation = (hi - lo) / sum
+						}
+					}
+				}
+
+				alpha := float64(p[3]) / 0xff
+				scores[y*width+x] = alpha * (smartCropEdgeWeight*edge +
+					smartCropSkinWeight*skin +
+					smartCropSaturationWeight*saturation)
+			}
+		}
+	})
+
+	return scores, err
+}
@@ -0,0 +1,61 @@
+package superimage
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSmartCropFindsDetail(t *testing.T) {
+	// A flat gray image, wider than the crop, with a saturated checkerboard
+	// in the right part.
+	img := image.NewNRGBA(image.Rect(-50, 10, 550, 210))
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			c := color.NRGBA{0x80, 0x80, 0x80, 0xff}
+			if x >= 400 && x < 500 && (x/4+y/4)%2 == 0 {
+				c = color.NRGBA{0xff, 0x20, 0x10, 0xff}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	rect, got, err := SmartCrop(img, 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rect.Dx() != 200 || rect.Dy() != 200 || !rect.In(img.Rect) {
+		t.Fatalf("rect = %v, want a 200x200 square inside %v", rect, img.Rect)
+	}
+	if !image.Rect(400, 10, 500, 210).In(rect) {
+		t.Fatalf("rect = %v, want it to hold the checkerboard", rect)
+	}
+	if got.Bounds() != image.Rect(0, 0, 100, 100) {
+		t.Fatalf("bounds = %v, want (0,0)-(100,100)", got.Bounds())
+	}
+}
+
+func TestSmartCropFlatImageIsCentered(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 300, 100))
+	rect, _, err := SmartCrop(img, 50, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The window slides over a smaller copy, so the center may be a pixel off.
+	if rect.Size() != image.Pt(100, 100) || rect.Min.Y != 0 || rect.Min.X < 99 || rect.Min.X > 101 {
+		t.Fatalf("rect = %v, want (100,0)-(200,100)", rect)
+	}
+
+	// The same aspect ratio keeps the whole image.
+	rect, got, err := SmartCrop(img, 30, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rect != img.Rect || got.Bounds() != image.Rect(0, 0, 30, 10) {
+		t.Fatalf("rect %v and bounds %v, want %v and (0,0)-(30,10)", rect, got.Bounds(), img.Rect)
+	}
+
+	if _, _, err := SmartCrop(img, 0, 10); err != ErrInvalidSize {
+		t.Errorf("SmartCrop to 0x10: err = %v, want %v", err, ErrInvalidSize)
+	}
+}
@@ -9,8 +9,14 @@ import (
 type SuperImage struct {
 	image.Image
 
-	// Image format: png, jpg, jpeg.
+	// Image format: the name of a registered format, like png, jpeg, gif or tiff.
 	format string
+
+	// EXIF orientation found when decoding, OrientationUnknown if none.
+	orientation int
+
+	// Metadata found when decoding, written back by Encode.
+	metadata *Metadata
 }
 
 func New(im image.Image, format string) *SuperImage {
@@ -23,3 +29,40 @@ func New(im image.Image, format string) *SuperImage {
 func (si SuperImage) Format() string {
 	return si.format
 }
+
+// Orientation returns the EXIF orientation read when the image was decoded,
+// or OrientationUnknown. Unless DecodeOptions.IgnoreOrientation was set, the
+// orientation is already applied to the pixels.
+func (si SuperImage) Orientation() int {
+	return si.orientation
+}
+
+// Metadata returns the metadata of the image, or nil if it has none.
+// Images derived from it by the effects of this package keep a copy of it.
+func (si SuperImage) Metadata() *Metadata {
+	return si.metadata
+}
+
+// WithMetadata returns a copy of the image with the given metadata.
+// A nil metadata removes it.
+func (si SuperImage) WithMetadata(md *Metadata) *SuperImage {
+	si.metadata = md
+	return &si
+}
+
+// As returns a copy of the image that is encoded in the given format, for example
+// to convert a decoded jpeg to png. The format can be any registered name or alias,
+// or FormatAuto to choose png or jpeg from the transparency of the image.
+func (si SuperImage) As(format string) *SuperImage {
+	si.metadata = si.metadata.Clone()
+	switch f, err := lookupFormat(format); {
+	case format == FormatAuto:
+		si.format = autoFormat(si.Image)
+	case err == nil:
+		si.format = f.name
+	default:
+		// Encode reports the unknown format.
+		si.format = format
+	}
+	return &si
+}
@@ -0,0 +1,744 @@
+package superimage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"slices"
+)
+
+// TIFFCompression is the compression of the image data of a TIFF file.
+type TIFFCompression int
+
+const (
+	TIFFUncompressed TIFFCompression = iota
+	TIFFDeflate
+	TIFFLZW
+)
+
+// TIFFOptions are the options of the TIFF encoder. A nil *TIFFOptions writes
+// uncompressed files.
+type TIFFOptions struct {
+	Compression TIFFCompression
+
+	// Predictor stores the difference between neighbor samples instead of the samples,
+	// which usually makes compressed photos smaller.
+	Predictor bool
+}
+
+// TIFF tags read or written by this package.
+const (
+	tiffImageWidth                = 256
+	tiffImageLength               = 257
+	tiffBitsPerSample             = 258
+	tiffCompression               = 259
+	tiffPhotometricInterpretation = 262
+	tiffStripOffsets              = 273
+	tiffSamplesPerPixel           = 277
+	tiffRowsPerStrip              = 278
+	tiffStripByteCounts           = 279
+	tiffXResolution               = 282
+	tiffYResolution               = 283
+	tiffPlanarConfiguration       = 284
+	tiffResolutionUnit            = 296
+	tiffPredictor                 = 317
+	tiffColorMap                  = 320
+	tiffTileWidth                 = 322
+	tiffExtraSamples              = 338
+	tiffSampleFormat              = 339
+)
+
+// TIFF field types.
+const (
+	tiffByte     = 1
+	tiffShort    = 3
+	tiffLong     = 4
+	tiffRational = 5
+)
+
+// Values of the Compression, PhotometricInterpretation, Predictor and ExtraSamples tags.
+const (
+	tiffCompressionNone       = 1
+	tiffCompressionLZW        = 5
+	tiffCompressionDeflate    = 8
+	tiffCompressionPackBits   = 32773
+	tiffCompressionOldDeflate = 32946
+
+	tiffWhiteIsZero = 0
+	tiffBlackIsZero = 1
+	tiffRGB         = 2
+	tiffPalette     = 3
+
+	tiffPredictorHorizontal = 2
+
+	tiffAssociatedAlpha   = 1
+	tiffUnassociatedAlpha = 2
+)
+
+// tiffStripSize is the approximate size of the strips written by the encoder.
+const tiffStripSize = 64 << 10
+
+// tiffTags reads the values of the integer tags of the IFD at offset ifd.
+func tiffTags(data []byte, order binary.ByteOrder, ifd int) (map[uint16][]uint32, error) {
+	if ifd < 8 || ifd+2 > len(data) {
+		return nil, formatError("tiff", "bad IFD offset")
+	}
+
+	n := int(order.Uint16(data[ifd:]))
+	if ifd+2+n*12 > len(data) {
+		return nil, formatError("tiff", "truncated IFD")
+	}
+
+	tags := make(map[uint16][]uint32, n)
+	for i := range n {
+		entry := data[ifd+2+i*12:]
+		tag, typ, count := order.Uint16(entry), order.Uint16(entry[2:]), uint64(order.Uint32(entry[4:]))
+
+		var size uint64
+		switch typ {
+		case tiffByte:
+			size = 1
+		case tiffShort:
+			size = 2
+		case tiffLong:
+			size = 4
+		default:
+			continue
+		}
+
+		value := entry[8:12]
+		if count*size > 4 {
+			offset := uint64(order.Uint32(entry[8:]))
+			if offset+count*size > uint64(len(data)) {
+				return nil, formatError("tiff", "bad tag value offset")
+			}
+			value = data[offset : offset+count*size]
+		}
+
+		values := make([]uint32, count)
+		for j := range values {
+			switch size {
+			case 1:
+				values[j] = uint32(value[j])
+			case 2:
+				values[j] = uint32(order.Uint16(value[j*2:]))
+			case 4:
+				values[j] = order.Uint32(value[j*4:])
+			}
+		}
+		tags[tag] = values
+	}
+
+	return tags, nil
+}
+
+// decodeTIFF decodes the first image of a baseline TIFF file: bilevel, gray,
+// paletted or RGB, with or without alpha, 8 or 16 bits per sample, stored in
+// strips without compression or with LZW, Deflate or PackBits.
+func decodeTIFF(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	order := exifByteOrder(data)
+	if len(data) < 8 || order == nil || order.Uint16(data[2:]) != 42 {
+		return nil, formatError("tiff", "bad header")
+	}
+
+	tags, err := tiffTags(data, order, int(order.Uint32(data[4:])))
+	if err != nil {
+		return nil, err
+	}
+	get := func(tag uint16, def uint32) int {
+		if v := tags[tag]; len(v) > 0 {
+			return int(v[0])
+		}
+		return int(def)
+	}
+
+	width, height := get(tiffImageWidth, 0), get(tiffImageLength, 0)
+	if width <= 0 || height <= 0 || width > maxImagePixels/height {
+		return nil, formatError("tiff", "bad image size")
+	}
+
+	switch {
+	case tags[tiffTileWidth] != nil:
+		return nil, formatError("tiff", "unsupported tiled image")
+	case get(tiffPlanarConfiguration, 1) != 1:
+		return nil, formatError("tiff", "unsupported planar configuration")
+	case get(tiffSampleFormat, 1) != 1:
+		return nil, formatError("tiff", "unsupported sample format")
+	}
+
+	samples := get(tiffSamplesPerPixel, 1)
+	depth := get(tiffBitsPerSample, 1)
+	for _, b := range tags[tiffBitsPerSample] {
+		if int(b) != depth {
+			return nil, formatError("tiff", "unsupported mixed bits per sample")
+		}
+	}
+	if samples < 1 || samples > 8 || depth != 1 && depth != 2 && depth != 4 && depth != 8 && depth != 16 {
+		return nil, formatError("tiff", "unsupported bits per sample")
+	}
+
+	rowBytes := (width*samples*depth + 7) / 8
+	pixels, err := tiffStrips(data, tags, rowBytes, height, get(tiffCompression, tiffCompressionNone))
+	if err != nil {
+		return nil, err
+	}
+
+	if get(tiffPredictor, 1) == tiffPredictorHorizontal {
+		if depth != 8 && depth != 16 {
+			return nil, formatError("tiff", "unsupported predictor")
+		}
+		for y := range height {
+			undoPredictor(pixels[y*rowBytes:(y+1)*rowBytes], samples, depth, order)
+		}
+	}
+
+	t := tiffImage{
+		pixels:   pixels,
+		rowBytes: rowBytes,
+		width:    width,
+		height:   height,
+		samples:  samples,
+		depth:    depth,
+		order:    order,
+	}
+
+	switch photometric := get(tiffPhotometricInterpretation, tiffBlackIsZero); photometric {
+	case tiffWhiteIsZero, tiffBlackIsZero:
+		return t.gray(photometric == tiffWhiteIsZero, get(tiffExtraSamples, 0))
+	case tiffRGB:
+		return t.rgb(get(tiffExtraSamples, 0))
+	case tiffPalette:
+		return t.paletted(tags[tiffColorMap])
+	default:
+		return nil, formatError("tiff", "unsupported photometric interpretation")
+	}
+}
+
+// tiffMaxRatio is the most bytes that a byte of a strip can decompress to with each
+// compression. Strips too short for their rows are rejected before decompressing them.
+var tiffMaxRatio = map[int]uint64{
+	tiffCompressionNone:       1,
+	tiffCompressionLZW:        lzwMaxCodes,
+	tiffCompressionDeflate:    deflateMaxRatio,
+	tiffCompressionOldDeflate: deflateMaxRatio,
+	tiffCompressionPackBits:   64,
+}
+
+// tiffStrips returns the decompressed strips of the image, rowBytes * height bytes.
+// The pixels grow with the decompressed strips, not with the size of the header.
+func tiffStrips(data []byte, tags map[uint16][]uint32, rowBytes, height, compression int) ([]byte, error) {
+	offsets, counts := tags[tiffStripOffsets], tags[tiffStripByteCounts]
+	if len(offsets) == 0 || len(counts) != len(offsets) {
+		return nil, formatError("tiff", "bad strips")
+	}
+
+	rowsPerStrip := height
+	if v := tags[tiffRowsPerStrip]; len(v) > 0 && v[0] > 0 {
+		rowsPerStrip = min(int(v[0]), height)
+	}
+
+	ratio, ok := tiffMaxRatio[compression]
+	if !ok {
+		return nil, formatError("tiff", "unsupported compression")
+	}
+
+	var pixels []byte
+	for i, offset := range offsets {
+		rows := min(rowsPerStrip, height-i*rowsPerStrip)
+		if rows <= 0 {
+			break
+		}
+		want := rows * rowBytes
+
+		if uint64(offset)+uint64(counts[i]) > uint64(len(data)) {
+			return nil, formatError("tiff", "bad strip offset")
+		}
+		strip := data[offset : offset+counts[i]]
+		if uint64(len(strip))*ratio < uint64(want) {
+			return nil, formatError("tiff", "truncated strip")
+		}
+
+		var raw []byte
+		var err error
+		switch compression {
+		case tiffCompressionNone:
+			raw = strip
+		case tiffCompressionLZW:
+			raw, err = tiffLZWDecode(strip, want)
+		case tiffCompressionDeflate, tiffCompressionOldDeflate:
+			var zr io.ReadCloser
+			if zr, err = zlib.NewReader(bytes.NewReader(strip)); err == nil {
+				raw, err = io.ReadAll(io.LimitReader(zr, int64(want)))
+				zr.Close()
+			}
+		case tiffCompressionPackBits:
+			raw, err = unpackBits(strip, want)
+		}
+		if err != nil {
+			return nil, formatError("tiff", err.Error())
+		}
+		if len(raw) < want {
+			return nil, formatError("tiff", "truncated strip")
+		}
+
+		pixels = append(pixels, raw[:want]...)
+	}
+
+	if len(pixels) < rowBytes*height {
+		return nil, formatError("tiff", "missing strips")
+	}
+	return pixels, nil
+}
+
+var errPackBits = errors.New("truncated PackBits data")
+
+// unpackBits decompresses PackBits data, stopping after max bytes. The output
+// grows with the decoded data, so max does not need to be trusted.
+func unpackBits(src []byte, max int) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(src) && len(out) < max; {
+		n := int(int8(src[i]))
+		i++
+
+		switch {
+		case n >= 0:
+			if i+n+1 > len(src) {
+				return nil, errPackBits
+			}
+			out = append(out, src[i:i+n+1]...)
+			i += n + 1
+		case n > -128:
+			if i >= len(src) {
+				return nil, errPackBits
+			}
+			for range 1 - n {
+				out = append(out, src[i])
+			}
+			i++
+		}
+	}
+
+	return out[:min(len(out), max)], nil
+}
+
+// undoPredictor turns the differences stored by the horizontal predictor into samples.
+func undoPredictor(row []byte, samples, depth int, order binary.ByteOrder) {
+	if depth == 8 {
+		for i := samples; i < len(row); i++ {
+			row[i] += row[i-samples]
+		}
+		return
+	}
+
+	for i := samples * 2; i+1 < len(row); i += 2 {
+		order.PutUint16(row[i:], order.Uint16(row[i:])+order.Uint16(row[i-samples*2:]))
+	}
+}
+
+// applyPredictor stores the differences between neighbor samples of a row, from
+// the end so every difference uses the original samples.
+func applyPredictor(row []byte, samples, depth int, order binary.ByteOrder) {
+	if depth == 8 {
+		for i := len(row) - 1; i >= samples; i-- {
+			row[i] -= row[i-samples]
+		}
+		return
+	}
+
+	for i := len(row) - 2; i >= samples*2; i -= 2 {
+		order.PutUint16(row[i:], order.Uint16(row[i:])-order.Uint16(row[i-samples*2:]))
+	}
+}
+
+// tiffImage holds the decompressed samples of a TIFF image.
+type tiffImage struct {
+	pixels                  []byte
+	rowBytes, width, height int
+	samples, depth          int
+	order                   binary.ByteOrder
+}
+
+// sample returns the i-th sample of row y, for depths lower than 16.
+func (t *tiffImage) sample(y, i int) uint8 {
+	bit := i * t.depth
+	b := t.pixels[y*t.rowBytes+bit/8]
+	return b >> (8 - t.depth - bit%8) & (1<<t.depth - 1)
+}
+
+// sample16 returns the i-th sample of row y of a 16-bit image.
+func (t *tiffImage) sample16(y, i int) uint16 {
+	return t.order.Uint16(t.pixels[y*t.rowBytes+i*2:])
+}
+
+func (t *tiffImage) gray(whiteIsZero bool, extra int) (image.Image, error) {
+	rect := image.Rect(0, 0, t.width, t.height)
+	invert8, invert16 := uint8(0), uint16(0)
+	if whiteIsZero {
+		invert8, invert16 = 0xff, 0xffff
+	}
+
+	switch {
+	case t.samples == 1 && t.depth < 16:
+		img := image.NewGray(rect)
+		scale := 0xff / (1<<t.depth - 1)
+		for y := range t.height {
+			for x := range t.width {
+				img.Pix[y*img.Stride+x] = t.sample(y, x)*uint8(scale) ^ invert8
+			}
+		}
+		return img, nil
+
+	case t.samples == 1:
+		img := image.NewGray16(rect)
+		for y := range t.height {
+			for x := range t.width {
+				binary.BigEndian.PutUint16(img.Pix[y*img.Stride+x*2:], t.sample16(y, x)^invert16)
+			}
+		}
+		return img, nil
+
+	case t.depth == 8:
+		img := image.NewNRGBA(rect)
+		for y := range t.height {
+			for x := range t.width {
+				g, a := t.sample(y, x*t.samples)^invert8, t.sample(y, x*t.samples+1)
+				if extra == tiffAssociatedAlpha {
+					g = unpremultiply(g, a)
+				}
+				copy(img.Pix[y*img.Stride+x*4:], []uint8{g, g, g, a})
+			}
+		}
+		return img, nil
+
+	case t.depth == 16:
+		img := image.NewNRGBA64(rect)
+		for y := range t.height {
+			for x := range t.width {
+				g, a := t.sample16(y, x*t.samples)^invert16, t.sample16(y, x*t.samples+1)
+				c := color.NRGBA64{R: g, G: g, B: g, A: a}
+				if extra == tiffAssociatedAlpha {
+					c = color.NRGBA64Model.Convert(color.RGBA64{R: g, G: g, B: g, A: a}).(color.NRGBA64)
+				}
+				img.SetNRGBA64(x, y, c)
+			}
+		}
+		return img, nil
+	}
+
+	return nil, formatError("tiff", "unsupported gray image")
+}
+
+func (t *tiffImage) rgb(extra int) (image.Image, error) {
+	rect := image.Rect(0, 0, t.width, t.height)
+	alpha := t.samples >= 4
+
+	switch {
+	case t.samples < 3:
+		return nil, formatError("tiff", "bad samples per pixel")
+
+	case t.depth == 8 && alpha && extra == tiffAssociatedAlpha:
+		img := image.NewRGBA(rect)
+		for y := range t.height {
+			for x := range t.width {
+				copy(img.Pix[y*img.Stride+x*4:], t.pixels[y*t.rowBytes+x*t.samples:][:4])
+			}
+		}
+		return img, nil
+
+	case t.depth == 8:
+		img := image.NewNRGBA(rect)
+		for y := range t.height {
+			for x := range t.width {
+				p := img.Pix[y*img.Stride+x*4:]
+				copy(p, t.pixels[y*t.rowBytes+x*t.samples:][:3])
+				p[3] = 0xff
+				if alpha {
+					p[3] = t.pixels[y*t.rowBytes+x*t.samples+3]
+				}
+			}
+		}
+		return img, nil
+
+	case t.depth == 16 && alpha && extra == tiffAssociatedAlpha:
+		img := image.NewRGBA64(rect)
+		for y := range t.height {
+			for x := range t.width {
+				i := x * t.samples
+				img.SetRGBA64(x, y, color.RGBA64{t.sample16(y, i), t.sample16(y, i+1), t.sample16(y, i+2), t.sample16(y, i+3)})
+			}
+		}
+		return img, nil
+
+	case t.depth == 16:
+		img := image.NewNRGBA64(rect)
+		for y := range t.height {
+			for x := range t.width {
+				i := x * t.samples
+				c := color.NRGBA64{t.sample16(y, i), t.sample16(y, i+1), t.sample16(y, i+2), 0xffff}
+				if alpha {
+					c.A = t.sample16(y, i+3)
+				}
+				img.SetNRGBA64(x, y, c)
+			}
+		}
+		return img, nil
+	}
+
+	return nil, formatError("tiff", "unsupported RGB image")
+}
+
+func (t *tiffImage) paletted(colorMap []uint32) (image.Image, error) {
+	if t.samples != 1 || t.depth > 8 || len(colorMap) != 3<<t.depth {
+		return nil, formatError("tiff", "bad color map")
+	}
+
+	n := 1 << t.depth
+	palette := make(color.Palette, n)
+	for i := range palette {
+		palette[i] = color.RGBA64{uint16(colorMap[i]), uint16(colorMap[n+i]), uint16(colorMap[2*n+i]), 0xffff}
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, t.width, t.height), palette)
+	for y := range t.height {
+		for x := range t.width {
+			img.Pix[y*img.Stride+x] = t.sample(y, x)
+		}
+	}
+	return img, nil
+}
+
+// tiffEntry is a tag of the IFD written by the encoder.
+type tiffEntry struct {
+	tag, typ uint16
+	// Rationals take two values, numerator and denominator.
+	values []uint32
+}
+
+// encodeTIFF writes a big-endian baseline TIFF file with a single image in strips.
+// Gray, paletted and 16-bit images keep their type, the others are stored as 8-bit
+// RGB, or RGBA with unassociated alpha if they have transparent pixels.
+func encodeTIFF(w io.Writer, m image.Image, opts *EncodeOptions) error {
+	bounds := m.Bounds()
+	if bounds.Empty() {
+		return ErrInvalidSize
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+
+	tiffOpts := opts.TiffOpts
+	if tiffOpts == nil {
+		tiffOpts = &TIFFOptions{}
+	}
+
+	photometric, samples, depth, extra := tiffRGB, 4, 8, tiffUnassociatedAlpha
+	var colorMap []uint32
+	var row func(y int, dst []byte)
+
+	switch img := m.(type) {
+	case *image.Gray:
+		photometric, samples, extra = tiffBlackIsZero, 1, 0
+		row = func(y int, dst []byte) {
+			i := img.PixOffset(bounds.Min.X, y)
+			copy(dst, img.Pix[i:i+width])
+		}
+
+	case *image.Gray16:
+		photometric, samples, depth, extra = tiffBlackIsZero, 1, 16, 0
+		row = func(y int, dst []byte) {
+			i := img.PixOffset(bounds.Min.X, y)
+			copy(dst, img.Pix[i:i+width*2])
+		}
+
+	case *image.NRGBA64, *image.RGBA64:
+		depth = 16
+		if isOpaque(img) {
+			samples, extra = 3, 0
+		}
+		row = func(y int, dst []byte) {
+			for x := range width {
+				c := color.NRGBA64Model.Convert(img.At(bounds.Min.X+x, y)).(color.NRGBA64)
+				d := dst[x*samples*2:]
+				binary.BigEndian.PutUint16(d, c.R)
+				binary.BigEndian.PutUint16(d[2:], c.G)
+				binary.BigEndian.PutUint16(d[4:], c.B)
+				if samples == 4 {
+					binary.BigEndian.PutUint16(d[6:], c.A)
+				}
+			}
+		}
+
+	case *image.Paletted:
+		if len(img.Palette) <= 256 && isOpaquePalette(img.Palette) {
+			photometric, samples, extra = tiffPalette, 1, 0
+			colorMap = make([]uint32, 3*256)
+			for i, c := range img.Palette {
+				r, g, b, _ := c.RGBA()
+				colorMap[i], colorMap[256+i], colorMap[512+i] = r, g, b
+			}
+			row = func(y int, dst []byte) {
+				i := img.PixOffset(bounds.Min.X, y)
+				copy(dst, img.Pix[i:i+width])
+			}
+		}
+	}
+
+	if row == nil {
+		if isOpaque(m) {
+			samples, extra = 3, 0
+		}
+		s := newScanner(m)
+		pixels := make([]uint8, width*4)
+		row = func(y int, dst []byte) {
+			s.scan(bounds.Min.X, y, bounds.Max.X, pixels)
+			if samples == 4 {
+				copy(dst, pixels)
+				return
+			}
+			for x := range width {
+				copy(dst[x*3:x*3+3], pixels[x*4:x*4+3])
+			}
+		}
+	}
+
+	rowBytes := width * samples * depth / 8
+	rowsPerStrip := max(1, tiffStripSize/rowBytes)
+
+	compression := tiffCompressionNone
+	switch tiffOpts.Compression {
+	case TIFFDeflate:
+		compression = tiffCompressionDeflate
+	case TIFFLZW:
+		compression = tiffCompressionLZW
+	}
+	predictor := tiffOpts.Predictor && compression != tiffCompressionNone && photometric != tiffPalette
+
+	// The strips come right after the header, the IFD after them.
+	var buf bytes.Buffer
+	buf.WriteString("MM\x00\x2a\x00\x00\x00\x00")
+
+	var offsets, counts []uint32
+	strip := make([]byte, 0, rowsPerStrip*rowBytes)
+	for y := 0; y < height; y += rowsPerStrip {
+		rows := min(rowsPerStrip, height-y)
+		strip = strip[:rows*rowBytes]
+		for i := range rows {
+			line := strip[i*rowBytes : (i+1)*rowBytes]
+			row(bounds.Min.Y+y+i, line)
+			if predictor {
+				applyPredictor(line, samples, depth, binary.BigEndian)
+			}
+		}
+
+		data := strip
+		switch compression {
+		case tiffCompressionDeflate:
+			var z bytes.Buffer
+			zw := zlib.NewWriter(&z)
+			zw.Write(strip)
+			zw.Close()
+			data = z.Bytes()
+		case tiffCompressionLZW:
+			data = tiffLZWEncode(strip)
+		}
+
+		offsets = append(offsets, uint32(buf.Len()))
+		counts = append(counts, uint32(len(data)))
+		buf.Write(data)
+	}
+	if buf.Len()%2 == 1 {
+		buf.WriteByte(0)
+	}
+
+	bitsPerSample := make([]uint32, samples)
+	for i := range bitsPerSample {
+		bitsPerSample[i] = uint32(depth)
+	}
+
+	entries := []tiffEntry{
+		{tiffImageWidth, tiffLong, []uint32{uint32(width)}},
+		{tiffImageLength, tiffLong, []uint32{uint32(height)}},
+		{tiffBitsPerSample, tiffShort, bitsPerSample},
+		{tiffCompression, tiffShort, []uint32{uint32(compression)}},
+		{tiffPhotometricInterpretation, tiffShort, []uint32{uint32(photometric)}},
+		{tiffStripOffsets, tiffLong, offsets},
+		{tiffSamplesPerPixel, tiffShort, []uint32{uint32(samples)}},
+		{tiffRowsPerStrip, tiffLong, []uint32{uint32(rowsPerStrip)}},
+		{tiffStripByteCounts, tiffLong, counts},
+		{tiffXResolution, tiffRational, []uint32{72, 1}},
+		{tiffYResolution, tiffRational, []uint32{72, 1}},
+		{tiffPlanarConfiguration, tiffShort, []uint32{1}},
+		{tiffResolutionUnit, tiffShort, []uint32{2}},
+	}
+	if predictor {
+		entries = append(entries, tiffEntry{tiffPredictor, tiffShort, []uint32{tiffPredictorHorizontal}})
+	}
+	if colorMap != nil {
+		entries = append(entries, tiffEntry{tiffColorMap, tiffShort, colorMap})
+	}
+	if extra != 0 && (samples == 2 || samples == 4) {
+		entries = append(entries, tiffEntry{tiffExtraSamples, tiffShort, []uint32{uint32(extra)}})
+	}
+	slices.SortFunc(entries, func(a, b tiffEntry) int {
+		return int(a.tag) - int(b.tag)
+	})
+
+	writeTIFFIFD(&buf, entries)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeTIFFIFD appends the IFD of entries to buf, with the values that do not
+// fit in an entry after it, and points the header to it.
+func writeTIFFIFD(buf *bytes.Buffer, entries []tiffEntry) {
+	order := binary.BigEndian
+	ifd := buf.Len()
+	order.PutUint32(buf.Bytes()[4:], uint32(ifd))
+
+	extra := ifd + 2 + len(entries)*12 + 4
+	var values []byte
+
+	ifdBytes := order.AppendUint16(nil, uint16(len(entries)))
+	for _, e := range entries {
+		var data []byte
+		count := len(e.values)
+		for _, v := range e.values {
+			if e.typ == tiffShort {
+				data = order.AppendUint16(data, uint16(v))
+			} else {
+				data = order.AppendUint32(data, v)
+			}
+		}
+		if e.typ == tiffRational {
+			count /= 2
+		}
+
+		ifdBytes = order.AppendUint16(ifdBytes, e.tag)
+		ifdBytes = order.AppendUint16(ifdBytes, e.typ)
+		ifdBytes = order.AppendUint32(ifdBytes, uint32(count))
+		if len(data) <= 4 {
+			ifdBytes = append(ifdBytes, data...)
+			ifdBytes = append(ifdBytes, make([]byte, 4-len(data))...)
+			continue
+		}
+
+		ifdBytes = order.AppendUint32(ifdBytes, uint32(extra+len(values)))
+		values = append(values, data...)
+		if len(values)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+
+	// No next IFD.
+	ifdBytes = order.AppendUint32(ifdBytes, 0)
+
+	buf.Write(ifdBytes)
+	buf.Write(values)
+}
@@ -0,0 +1,122 @@
+package superimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func TestTIFFRoundTrip(t *testing.T) {
+	r := image.Rect(0, 0, 45, 17)
+	gray := image.NewGray(r)
+	gray16 := image.NewGray16(r)
+	nrgba := image.NewNRGBA(r)
+	nrgba64 := image.NewNRGBA64(r)
+	paletted := image.NewPaletted(r, color.Palette{color.Black, color.White, color.NRGBA{0x80, 0x20, 0x10, 0xff}})
+	for y := range r.Dy() {
+		for x := range r.Dx() {
+			gray.SetGray(x, y, color.Gray{uint8(x * y)})
+			gray16.SetGray16(x, y, color.Gray16{uint16(x*1733 + y*31)})
+			nrgba.SetNRGBA(x, y, color.NRGBA{uint8(x * 5), uint8(y * 15), 0x40, uint8(x * y)})
+			nrgba64.SetNRGBA64(x, y, color.NRGBA64{uint16(x * 1000), uint16(y * 3000), 0x4000, 0xffff})
+			paletted.SetColorIndex(x, y, uint8((x+y)%3))
+		}
+	}
+
+	images := []typedImage{
+		{"Gray", gray},
+		{"Gray16", gray16},
+		{"NRGBA", nrgba},
+		{"NRGBA64", nrgba64},
+		{"Paletted", paletted},
+	}
+	compressions := []struct {
+		name        string
+		compression TIFFCompression
+	}{
+		{"uncompressed", TIFFUncompressed},
+		{"deflate", TIFFDeflate},
+		{"lzw", TIFFLZW},
+	}
+	for _, ti := range images {
+		for _, c := range compressions {
+			for _, predictor := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%s/%s/predictor=%v", ti.name, c.name, predictor), func(t *testing.T) {
+					opts := &EncodeOptions{Format: "tiff", TiffOpts: &TIFFOptions{Compression: c.compression, Predictor: predictor}}
+					got := roundTrip(t, ti.img, opts)
+					assertSameNRGBA(t, got, ti.img)
+				})
+			}
+		}
+	}
+}
+
+// tiffFile returns a big-endian TIFF of a gray image whose single strip holds data.
+func tiffFile(width, height, depth, compression uint32, data []byte) []byte {
+	tags := [][2]uint32{
+		{tiffImageWidth, width},
+		{tiffImageLength, height},
+		{tiffBitsPerSample, depth},
+		{tiffCompression, compression},
+		{tiffPhotometricInterpretation, tiffBlackIsZero},
+		{tiffStripOffsets, 0},
+		{tiffSamplesPerPixel, 1},
+		{tiffRowsPerStrip, height},
+		{tiffStripByteCounts, uint32(len(data))},
+	}
+	ifdSize := 2 + len(tags)*12 + 4
+	tags[5][1] = uint32(8 + ifdSize)
+
+	b := []byte("MM\x00*")
+	b = binary.BigEndian.AppendUint32(b, 8)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(tags)))
+	for _, tag := range tags {
+		b = binary.BigEndian.AppendUint16(b, uint16(tag[0]))
+		b = binary.BigEndian.AppendUint16(b, tiffLong)
+		b = binary.BigEndian.AppendUint32(b, 1)
+		b = binary.BigEndian.AppendUint32(b, tag[1])
+	}
+	b = binary.BigEndian.AppendUint32(b, 0)
+	return append(b, data...)
+}
+
+func TestTIFFRejectsTruncated(t *testing.T) {
+	strip := slices.Repeat([]byte{0x80}, 100)
+	tests := map[string][]byte{
+		"none":          tiffFile(16384, 16384, 8, tiffCompressionNone, strip),
+		"lzw":           tiffFile(16384, 16384, 8, tiffCompressionLZW, strip),
+		"lzw 16-bit":    tiffFile(16384, 16384, 16, tiffCompressionLZW, strip),
+		"deflate":       tiffFile(16384, 16384, 8, tiffCompressionDeflate, strip),
+		"packbits":      tiffFile(16384, 16384, 8, tiffCompressionPackBits, strip),
+		"short strip":   tiffFile(100, 100, 8, tiffCompressionNone, strip),
+		"bad lzw":       tiffFile(10, 10, 8, tiffCompressionLZW, strip),
+		"header only":   tiffFile(16384, 16384, 8, tiffCompressionNone, nil)[:40],
+		"too big":       tiffFile(1<<20, 1<<20, 8, tiffCompressionNone, strip),
+		"bad signature": []byte("MM\x00\x2b\x00\x00\x00\x08"),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertRejected(t, "tiff", data)
+		})
+	}
+}
+
+func TestTIFFDecodesHandWrittenStrip(t *testing.T) {
+	// Two rows of PackBits: a run of four 0x10 and the literal bytes 1, 2, 3, 4.
+	data := tiffFile(4, 2, 8, tiffCompressionPackBits, []byte{0xfd, 0x10, 0x03, 1, 2, 3, 4})
+	img, err := decodeTIFF(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		t.Fatalf("decoded a %T, want *image.Gray", img)
+	}
+	if want := []uint8{0x10, 0x10, 0x10, 0x10, 1, 2, 3, 4}; !slices.Equal(gray.Pix, want) {
+		t.Fatalf("pixels = %v, want %v", gray.Pix, want)
+	}
+}
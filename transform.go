@@ -0,0 +1,297 @@
+package superimage
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Interpolation selects how Rotate samples the source image between pixels.
+type Interpolation int
+
+const (
+	// NearestInterpolation takes the closest pixel. Fast, but edges look jagged.
+	NearestInterpolation Interpolation = iota
+	// BilinearInterpolation mixes the 4 closest pixels.
+	BilinearInterpolation
+	// BicubicInterpolation mixes the 16 closest pixels with a Catmull-Rom spline.
+	BicubicInterpolation
+)
+
+// Crop returns the part of an image inside rect as a new *SuperImage whose bounds start at (0, 0).
+// The rectangle is clipped to the image bounds, so the result may be smaller or empty.
+func Crop(img image.Image, rect image.Rectangle) *SuperImage {
+	cropped, _ := crop(img, rect, nil)
+	return newLike(cropped, img)
+}
+
+func crop(img image.Image, rect image.Rectangle, opts *Options) (*image.NRGBA, error) {
+	rect = rect.Intersect(img.Bounds())
+	width := rect.Dx()
+	cropped := image.NewNRGBA(image.Rect(0, 0, width, rect.Dy()))
+	s := newScanner(img)
+
+	err := parallelFor(opts, rect.Dy(), func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			i := cropped.PixOffset(0, y)
+			s.scan(rect.Min.X, rect.Min.Y+y, rect.Max.X, cropped.Pix[i:i+width*4])
+		}
+	})
+
+	return cropped, err
+}
+
+// Rotate90 rotates an image 90 degrees counter-clockwise without losing quality.
+func Rotate90(img image.Image) *SuperImage {
+	return newLike(mapPixels(img, true, false, true), img)
+}
+
+// Rotate180 rotates an image 180 degrees without losing quality.
+func Rotate180(img image.Image) *SuperImage {
+	return newLike(mapPixels(img, false, true, true), img)
+}
+
+// Rotate270 rotates an image 270 degrees counter-clockwise (90 clockwise) without losing quality.
+func Rotate270(img image.Image) *SuperImage {
+	return newLike(mapPixels(img, true, true, false), img)
+}
+
+// Transpose flips an image over its top-left to bottom-right diagonal.
+func Transpose(img image.Image) *SuperImage {
+	return newLike(mapPixels(img, true, false, false), img)
+}
+
+// Transverse flips an image over its top-right to bottom-left diagonal.
+func Transverse(img image.Image) *SuperImage {
+	return newLike(mapPixels(img, true, true, true), img)
+}
+
+// mapPixels copies every pixel of img to a new image whose bounds start at (0, 0).
+// With swap, the source rows become the destination columns. Then mirrorX and
+// mirrorY mirror the destination horizontally and vertically.
+func mapPixels(img image.Image, swap, mirrorX, mirrorY bool) *image.NRGBA {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+
+	dstW, dstH := width, height
+	if swap {
+		dstW, dstH = height, width
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	s := newScanner(img)
+
+	_ = parallelFor(nil, height, func(startY, endY int) {
+		row := make([]uint8, width*4)
+		for y := startY; y < endY; y++ {
+			s.scan(bounds.Min.X, bounds.Min.Y+y, bounds.Max.X, row)
+
+			// Destination of the first pixel of the row, and the offset
+			// between two consecutive pixels of the row.
+			dx, dy, stepX, stepY := 0, y, 1, 0
+			if swap {
+				dx, dy, stepX, stepY = y, 0, 0, 1
+			}
+			if mirrorX {
+				dx, stepX = dstW-1-dx, -stepX
+			}
+			if mirrorY {
+				dy, stepY = dstH-1-dy, -stepY
+			}
+
+			o := dst.PixOffset(dx, dy)
+			step := stepX*4 + stepY*dst.Stride
+			for j := 0; j < len(row); j, o = j+4, o+step {
+				copy(dst.Pix[o:o+4], row[j:j+4])
+			}
+		}
+	})
+
+	return dst
+}
+
+// Rotate rotates an image counter-clockwise by the given degrees. The canvas grows to
+// fit the whole rotated image and the uncovered areas are filled with bg.
+// Multiples of 90 degrees use the lossless rotations.
+// If degrees is NaN or infinite, it returns an error.
+func Rotate(img image.Image, degrees float64, bg color.Color, interp Interpolation) (*SuperImage, error) {
+	if math.IsNaN(degrees) || math.IsInf(degrees, 0) {
+		return nil, ErrInvalidAngle
+	}
+
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+
+	switch degrees {
+	case 0:
+		return Crop(img, img.Bounds()), nil
+	case 90:
+		return Rotate90(img), nil
+	case 180:
+		return Rotate180(img), nil
+	case 270:
+		return Rotate270(img), nil
+	}
+
+	rotated, err := rotate(img, degrees, bg, interp, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLike(rotated, img), nil
+}
+
+func rotate(img image.Image, degrees float64, bg color.Color, interp Interpolation, opts *Options) (*image.NRGBA, error) {
+	src, err := toNRGBA(img, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	width := float64(bounds.Dx())
+	height := float64(bounds.Dy())
+
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	dstW := int(math.Ceil(math.Abs(width*cos) + math.Abs(height*sin) - 1e-6))
+	dstH := int(math.Ceil(math.Abs(width*sin) + math.Abs(height*cos) - 1e-6))
+	rotated := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+
+	sampler := newSampler(src, bg)
+	srcCX, srcCY := width/2, height/2
+	dstCX, dstCY := float64(dstW)/2, float64(dstH)/2
+
+	err = parallelFor(opts, dstH, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := range dstW {
+				// Inverse rotation of the pixel center. The y axis points down,
+				// so a counter-clockwise rotation on screen uses the opposite sign.
+				px := float64(x) + 0.5 - dstCX
+				py := float64(y) + 0.5 - dstCY
+				sx := px*cos - py*sin + srcCX - 0.5
+				sy := px*sin + py*cos + srcCY - 0.5
+
+				var c [4]float64
+				switch interp {
+				case BilinearInterpolation:
+					c = sampler.bilinear(sx, sy)
+				case BicubicInterpolation:
+					c = sampler.bicubic(sx, sy)
+				default:
+					c = sampler.at(int(math.Floor(sx+0.5)), int(math.Floor(sy+0.5)))
+				}
+
+				i := rotated.PixOffset(x, y)
+				storePremultiplied(rotated.Pix[i:i+4:i+4], c)
+			}
+		}
+	})
+
+	return rotated, err
+}
+
+// sampler reads premultiplied pixels of an image relative to its Min point,
+// returning a background color outside of it.
+type sampler struct {
+	img *image.NRGBA
+	bg  [4]float64
+}
+
+func newSampler(img *image.NRGBA, bg color.Color) *sampler {
+	s := &sampler{img: img}
+	if bg != nil {
+		r, g, b, a := bg.RGBA()
+		s.bg = [4]float64{float64(r) / 0x101, float64(g) / 0x101, float64(b) / 0x101, float64(a) / 0x101}
+	}
+	return s
+}
+
+func (s *sampler) at(x, y int) [4]float64 {
+	if x < 0 || y < 0 || x >= s.img.Rect.Dx() || y >= s.img.Rect.Dy() {
+		return s.bg
+	}
+
+	i := s.img.PixOffset(s.img.Rect.Min.X+x, s.img.Rect.Min.Y+y)
+	p := s.img.Pix[i : i+4 : i+4]
+	a := float64(p[3]) / 0xff
+	return [4]float64{float64(p[0]) * a, float64(p[1]) * a, float64(p[2]) * a, float64(p[3])}
+}
+
+func (s *sampler) bilinear(x, y float64) [4]float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	ix, iy := int(x0), int(y0)
+
+	var c [4]float64
+	for j, wy := range [2]float64{1 - fy, fy} {
+		for i, wx := range [2]float64{1 - fx, fx} {
+			p := s.at(ix+i, iy+j)
+			for k := range c {
+				c[k] += p[k] * wx * wy
+			}
+		}
+	}
+
+	return c
+}
+
+func (s *sampler) bicubic(x, y float64) [4]float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	ix, iy := int(x0), int(y0)
+
+	var c [4]float64
+	for j := -1; j <= 2; j++ {
+		wy := catmullRom(float64(j) - fy)
+		for i := -1; i <= 2; i++ {
+			wx := catmullRom(float64(i) - fx)
+			p := s.at(ix+i, iy+j)
+			for k := range c {
+				c[k] += p[k] * wx * wy
+			}
+		}
+	}
+
+	return c
+}
+
+// catmullRom is the Catmull-Rom cubic spline kernel.
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	default:
+		return 0
+	}
+}
+
+// storePremultiplied writes a premultiplied color in the 0-255 range as an NRGBA pixel.
+func storePremultiplied(p []uint8, c [4]float64) {
+	a := clampUint8(c[3])
+	if a == 0 {
+		p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+		return
+	}
+
+	scale := 0xff / c[3]
+	p[0] = clampUint8(c[0] * scale)
+	p[1] = clampUint8(c[1] * scale)
+	p[2] = clampUint8(c[2] * scale)
+	p[3] = a
+}
+
+// clampUint8 rounds v to the closest value in [0, 255].
+func clampUint8(v float64) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 0xff:
+		return 0xff
+	default:
+		return uint8(v + 0.5)
+	}
+}
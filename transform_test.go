@@ -0,0 +1,112 @@
+package superimage
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestLosslessTransforms(t *testing.T) {
+	r := image.Rect(-3, 5, 6, 12)
+	w, h := r.Dx(), r.Dy()
+
+	// The pixel of the source, relative to its Min point, at (x, y) of the result.
+	transforms := []struct {
+		name     string
+		apply    func(image.Image) *SuperImage
+		swap     bool
+		sourceOf func(x, y int) (int, int)
+	}{
+		{"Rotate90", Rotate90, true, func(x, y int) (int, int) { return w - 1 - y, x }},
+		{"Rotate180", Rotate180, false, func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }},
+		{"Rotate270", Rotate270, true, func(x, y int) (int, int) { return y, h - 1 - x }},
+		{"Transpose", Transpose, true, func(x, y int) (int, int) { return y, x }},
+		{"Transverse", Transverse, true, func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }},
+	}
+	for _, ti := range scannerImages(r, image.Point{}) {
+		for _, tt := range transforms {
+			t.Run(ti.name+"/"+tt.name, func(t *testing.T) {
+				got := tt.apply(ti.img)
+				want := image.Rect(0, 0, w, h)
+				if tt.swap {
+					want = image.Rect(0, 0, h, w)
+				}
+				if got.Bounds() != want {
+					t.Fatalf("bounds = %v, want %v", got.Bounds(), want)
+				}
+				for y := range want.Dy() {
+					for x := range want.Dx() {
+						sx, sy := tt.sourceOf(x, y)
+						g := color.NRGBAModel.Convert(got.At(x, y))
+						s := color.NRGBAModel.Convert(ti.img.At(r.Min.X+sx, r.Min.Y+sy))
+						if g != s {
+							t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, s)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCrop(t *testing.T) {
+	img := scannerImages(image.Rect(10, 10, 30, 20), image.Point{})[0].img
+	tests := []struct {
+		rect image.Rectangle
+		want image.Rectangle
+	}{
+		{image.Rect(12, 11, 17, 19), image.Rect(2, 1, 7, 9)},
+		{image.Rect(0, 0, 15, 40), image.Rect(0, 0, 5, 10)},
+		{image.Rect(40, 40, 50, 50), image.Rectangle{}},
+	}
+	for _, tt := range tests {
+		got := Crop(img, tt.rect)
+		if got.Bounds() != image.Rect(0, 0, tt.want.Dx(), tt.want.Dy()) {
+			t.Fatalf("Crop(%v): bounds = %v, want size %v", tt.rect, got.Bounds(), tt.want.Size())
+		}
+		assertSameNRGBA(t, got, img.(*image.NRGBA).SubImage(tt.want.Add(image.Pt(10, 10))))
+	}
+}
+
+func TestRotate(t *testing.T) {
+	img := scannerImages(image.Rect(0, 0, 40, 20), image.Point{})[0].img
+
+	// Multiples of 90 degrees, in any turn, are the lossless rotations.
+	for _, degrees := range []float64{0, 90, -270, 180, 630} {
+		got, err := Rotate(img, degrees, color.Black, BilinearInterpolation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want image.Image = img
+		switch math.Mod(degrees+360, 360) {
+		case 90:
+			want = Rotate90(img)
+		case 180:
+			want = Rotate180(img)
+		case 270:
+			want = Rotate270(img)
+		}
+		assertSameNRGBA(t, got, want)
+	}
+
+	for _, interp := range []Interpolation{NearestInterpolation, BilinearInterpolation, BicubicInterpolation} {
+		got, err := Rotate(img, 45, color.Transparent, interp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The canvas holds the whole image, 30√2 x 30√2 rounded up.
+		if size := got.Bounds().Size(); size != image.Pt(43, 43) {
+			t.Fatalf("interpolation %d: size = %v, want (43,43)", interp, size)
+		}
+		if _, _, _, a := got.At(0, 0).RGBA(); a != 0 {
+			t.Fatalf("interpolation %d: corner is not the background", interp)
+		}
+	}
+
+	for _, degrees := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := Rotate(img, degrees, color.Black, NearestInterpolation); err != ErrInvalidAngle {
+			t.Errorf("Rotate(%v): err = %v, want %v", degrees, err, ErrInvalidAngle)
+		}
+	}
+}